@@ -3,11 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/costexpr"
 	"github.com/synful/cluster-simulate/graph/encoding"
 )
 
@@ -18,20 +19,33 @@ const (
 
 var (
 	graphFilename = flag.String("graph", "", "a file containing the graph to cluster")
+	graphFormat   = flag.String("format", "", "the format of the graph file: "+strings.Join(encoding.ReadableFormats(), ", ")+" (detected from its content or extension if empty)")
+	run           = flag.Bool("run", false, "show how the simulation which produced the graph was run (the graph file must be a snapshot written by simulate)")
 	basic         = flag.Bool("basic", false, "show basic whole-graph statistics such as number of clusters and nodes")
 	advanced      = flag.Bool("advanced", false, "show advanced whole-graph statistics such as average cost metrics")
 	clusters      = flag.String("clusters", "", "comma-separated list of clusters to perform cluster-specific analysis on, or \"all\"")
 	basicCluster  = flag.Bool("basicCluster", false, "show basic statistics for each cluster such as number of nodes and border nodes")
 	maxCost       = flag.Bool("maxCost", false, "compute the MaxCost metric for each cluster and the advanced statistics")
+	costExpr      = flag.String("cost", "", "an expression defining a custom cost function to compute for each cluster and the advanced statistics (see graph/costexpr)")
+	costFile      = flag.String("costFile", "", "a file containing an expression defining a custom cost function")
+	fib           = flag.Bool("fib", false, "compute the routing table size of every node and report on its distribution")
+	memory        = flag.Bool("memory", false, "compute the number of LSDB entries stored by every node and compare the total to a flat network")
 )
 
 var (
-	clusterAnalyzers = []func(*graph.Graph, *graph.Cluster){runBasicCluster, runMaxCost}
-	overallAnalyzers = []func(*graph.Graph){runOverallMaxCost}
+	clusterAnalyzers = []func(*graph.Graph, *graph.Cluster){runBasicCluster, runMaxCost, runCustomCost, runFIB, runMemory}
+	overallAnalyzers = []func(*graph.Graph){runOverallMaxCost, runOverallCustomCost, runOverallFIB, runOverallMemory}
 )
 
 var maxInt int
 
+// The custom cost function given by -cost or -costFile
+// (or nil if neither was given), and its description
+var (
+	customCost     graph.Cost
+	customCostName string
+)
+
 func init() {
 	u := uint(0)
 	u--
@@ -41,6 +55,16 @@ func init() {
 
 func main() {
 	flag.Parse()
+	encoding.Warnings = os.Stderr
+
+	if *costExpr != "" || *costFile != "" {
+		var err error
+		customCost, customCostName, err = costexpr.Load(*costExpr, *costFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading cost function: %v\n", err)
+			os.Exit(ERR_PARSE)
+		}
+	}
 
 	f, err := os.Open(*graphFilename)
 	if err != nil {
@@ -48,14 +72,39 @@ func main() {
 		os.Exit(ERR_IO)
 	}
 
-	gd, err := ioutil.ReadAll(f)
-	def, err := encoding.Unmarshal(gd)
+	cost := graph.MaxCost
+	if customCost != nil {
+		cost = customCost
+	}
+	format, r, err := encoding.Detect(f, *graphFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading graph file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+	if *graphFormat != "" {
+		format = *graphFormat
+	}
+	var g *graph.Graph
+	var snapshot *encoding.Snapshot
+	if format == "snapshot" {
+		var s encoding.Snapshot
+		s, err = encoding.ReadSnapshot(r)
+		if err == nil {
+			snapshot = &s
+			g, err = s.Restore(cost)
+		}
+	} else {
+		var d encoding.Decoder
+		d, err = encoding.NewFormatDecoder(format, r, *graphFilename, nil)
+		if err == nil {
+			g, err = encoding.ReadGraph(d, cost)
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing graph file: %v\n", err)
 		os.Exit(ERR_PARSE)
 	}
-
-	g := graph.NewGraph(def, graph.MaxCost)
+	f.Close()
 
 	var clusterList map[graph.ClusterID]*graph.Cluster
 	if *clusters == "all" {
@@ -81,7 +130,20 @@ func main() {
 	}
 
 	printedYet := false
+	if *run {
+		if snapshot == nil {
+			fmt.Fprintf(os.Stderr, "Graph file is not a snapshot; no run information available\n")
+		} else {
+			fmt.Println("RUN")
+			runRun(g, snapshot)
+			printedYet = true
+		}
+	}
+
 	if *basic {
+		if printedYet {
+			fmt.Println()
+		}
 		fmt.Println("BASIC STATISTICS")
 		runBasic(g)
 		printedYet = true
@@ -126,15 +188,34 @@ func runMaxCost(g *graph.Graph, c *graph.Cluster) {
 	fmt.Printf("    MaxCost: %v\n", graph.MaxCost(g, c.ClusterID()))
 }
 
+func runCustomCost(g *graph.Graph, c *graph.Cluster) {
+	if customCost == nil {
+		return
+	}
+	fmt.Printf("    Cost (%v): %v\n", customCostName, customCost(g, c.ClusterID()))
+}
+
 func runOverallMaxCost(g *graph.Graph) {
 	if !*maxCost {
 		return
 	}
+	printOverallCost(g, "MaxCost", graph.MaxCost)
+}
+
+func runOverallCustomCost(g *graph.Graph) {
+	if customCost == nil {
+		return
+	}
+	fmt.Printf("    Cost: %v\n", customCostName)
+	printOverallCost(g, "Cost", customCost)
+}
+
+func printOverallCost(g *graph.Graph, name string, f graph.Cost) {
 	cost := 0
 	highest, hcost := graph.ClusterID(""), 0
 	lowest, lcost := graph.ClusterID(""), maxInt
 	for _, c := range g.Clusters() {
-		tmpcost := graph.MaxCost(g, c.ClusterID())
+		tmpcost := f(g, c.ClusterID())
 		cost += tmpcost
 		if tmpcost > hcost {
 			highest, hcost = c.ClusterID(), tmpcost
@@ -144,13 +225,163 @@ func runOverallMaxCost(g *graph.Graph) {
 		}
 	}
 
-	fmt.Printf("    Average MaxCost: %v\n", float64(cost)/float64(g.NumClusters()))
-	fmt.Printf("    Total MaxCost: %v\n", cost)
-	fmt.Printf("    Cluster with highest MaxCost: %v (%v)\n", highest, hcost)
-	fmt.Printf("    Cluster with lowest MaxCost: %v (%v)\n", lowest, lcost)
+	fmt.Printf("    Average %v: %v\n", name, float64(cost)/float64(g.NumClusters()))
+	fmt.Printf("    Total %v: %v\n", name, cost)
+	fmt.Printf("    Cluster with highest %v: %v (%v)\n", name, highest, hcost)
+	fmt.Printf("    Cluster with lowest %v: %v (%v)\n", name, lowest, lcost)
+}
+
+// FIB sizes are expensive to compute, so
+// compute them at most once (see getFIBSizes).
+var fibSizes map[graph.NodeID]int
+
+func getFIBSizes(g *graph.Graph) map[graph.NodeID]int {
+	if fibSizes == nil {
+		fibSizes = g.FIBSizes()
+	}
+	return fibSizes
+}
+
+func runFIB(g *graph.Graph, c *graph.Cluster) {
+	if !*fib {
+		return
+	}
+	sizes := getFIBSizes(g)
+	total := 0
+	for nid := range c.Nodes() {
+		total += sizes[nid]
+	}
+	fmt.Printf("    Average FIB size: %.2f\n", float64(total)/float64(c.NumNodes()))
+}
+
+func runOverallFIB(g *graph.Graph) {
+	if !*fib {
+		return
+	}
+	sizes := make([]int, 0, g.NumNodes())
+	total := 0
+	for _, size := range getFIBSizes(g) {
+		sizes = append(sizes, size)
+		total += size
+	}
+	if len(sizes) == 0 {
+		return
+	}
+	sort.Ints(sizes)
+	percentile := func(p int) int {
+		return sizes[(len(sizes)-1)*p/100]
+	}
+	flat := g.FlatFIBSize()
+
+	fmt.Printf("    Total FIB entries: %v\n", total)
+	fmt.Printf("    Total FIB entries in flat network: %v\n", flat)
+	if flat > 0 {
+		fmt.Printf("    Ratio to flat network: %.4f\n", float64(total)/float64(flat))
+	}
+	fmt.Printf("    Average FIB size: %.2f\n", float64(total)/float64(len(sizes)))
+	fmt.Printf("    Smallest FIB size: %v\n", sizes[0])
+	fmt.Printf("    Median FIB size: %v\n", percentile(50))
+	fmt.Printf("    90th percentile FIB size: %v\n", percentile(90))
+	fmt.Printf("    99th percentile FIB size: %v\n", percentile(99))
+	fmt.Printf("    Largest FIB size: %v\n", sizes[len(sizes)-1])
+}
+
+func runMemory(g *graph.Graph, c *graph.Cluster) {
+	if !*memory {
+		return
+	}
+	total := 0
+	for nid := range c.Nodes() {
+		total += g.NodeLSDBSize(nid)
+	}
+	fmt.Printf("    Total LSDB entries: %v\n", total)
+	fmt.Printf("    Average LSDB entries per node: %.2f\n", float64(total)/float64(c.NumNodes()))
+}
+
+func runOverallMemory(g *graph.Graph) {
+	if !*memory {
+		return
+	}
+	total := g.TotalLSDBSize()
+	flat := g.FlatLSDBSize()
+
+	// Nodes of the same kind in the same cluster
+	// store the same number of entries, so only
+	// check one of each.
+	largest, lsize := graph.NodeID(""), 0
+	for _, c := range g.Clusters() {
+		checkedBorder, checkedInternal := false, false
+		for nid, n := range c.Nodes() {
+			if n.IsBorderNode() {
+				if checkedBorder {
+					continue
+				}
+				checkedBorder = true
+			} else {
+				if checkedInternal {
+					continue
+				}
+				checkedInternal = true
+			}
+			if size := g.NodeLSDBSize(nid); size > lsize {
+				largest, lsize = nid, size
+			}
+		}
+	}
+
+	fmt.Printf("    Total LSDB entries: %v\n", total)
+	fmt.Printf("    Total LSDB entries in flat network: %v\n", flat)
+	if flat > 0 {
+		fmt.Printf("    Ratio to flat network: %.4f\n", float64(total)/float64(flat))
+	}
+	fmt.Printf("    Average LSDB entries per node: %.2f\n", float64(total)/float64(g.NumNodes()))
+	fmt.Printf("    Node with most LSDB entries: %v (%v)\n", largest, lsize)
+}
+
+func runRun(g *graph.Graph, s *encoding.Snapshot) {
+	fmt.Printf("  Generator: %v\n", s.Generator)
+	fmt.Printf("  Rounds: %v\n", s.Round)
+	fmt.Printf("  Cost function: %v\n", s.Cost)
+	fmt.Printf("  Seed: %v\n", s.Seed)
+	var opts []string
+	for k, v := range s.Options {
+		opts = append(opts, k+"="+v)
+	}
+	sort.Strings(opts)
+	fmt.Printf("  Options: %v\n", strings.Join(opts, " "))
+	fmt.Printf("  Source: %v\n", s.Source)
+	if s.SourceHeader.Generator != "" {
+		fmt.Printf("  Source generator: %v\n", s.SourceHeader.Generator)
+	}
+	fmt.Printf("  Source fingerprint: %v\n", s.SourceFingerprint)
+	fmt.Printf("  Fingerprint: %v\n", s.Fingerprint)
+
+	// Count the merges performed in each round
+	merges := make([]int, s.Round+1)
+	leaves := 0
+	var visit func(m *graph.MergeNode)
+	visit = func(m *graph.MergeNode) {
+		if len(m.Children) == 0 {
+			leaves++
+			return
+		}
+		merges[m.Round]++
+		for _, c := range m.Children {
+			visit(c)
+		}
+	}
+	for _, root := range g.MergeHistory() {
+		visit(root)
+	}
+	fmt.Printf("  Original clusters: %v\n", leaves)
+	fmt.Printf("  Current clusters: %v\n", g.NumClusters())
+	for round, n := range merges[1:] {
+		fmt.Printf("  Merges in round %v: %v\n", round+1, n)
+	}
 }
 
 func runBasic(g *graph.Graph) {
+	fmt.Printf("  Fingerprint: %v\n", encoding.GraphFingerprint(g))
 	fmt.Printf("  Number of nodes: %v\n", g.NumNodes())
 	fmt.Printf("  Number of clusters: %v\n", g.NumClusters())
 	fmt.Printf("  Average nodes per cluster: %.2f\n", float64(g.NumNodes())/float64(g.NumClusters()))
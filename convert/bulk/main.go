@@ -1,32 +1,116 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+
+	"github.com/synful/cluster-simulate/graph/encoding"
 )
 
 var (
-	format  = flag.String("format", "edge-list", "the format of the input files")
-	input   = flag.String("input", ".", "the directory to search for input files")
-	output  = flag.String("output", ".", "the directory to write converted files")
-	verbose = flag.Bool("verbose", false, "")
+	format       = flag.String("format", "", "the format of the input files: "+strings.Join(encoding.ReadableFormats(), ", ")+"; if empty, each file's format is detected from its content or extension, defaulting to edge-list")
+	outputFormat = flag.String("outputFormat", "json", "the format of the converted files: "+strings.Join(encoding.WritableFormats(), ", "))
+	input        = flag.String("input", ".", "the directory to search for input files")
+	output       = flag.String("output", ".", "the directory to write converted files")
+	recursive    = flag.Bool("recursive", false, "search the subdirectories of the input directory, mirroring them in the output directory")
+	include      = flag.String("include", "", "a comma-separated list of glob patterns; if given, only files whose name or path (relative to the input directory) matches one are converted")
+	exclude      = flag.String("exclude", "", "a comma-separated list of glob patterns; files whose name or path (relative to the input directory) matches one are not converted")
+	skip         = flag.String("skip", "none", "which files to skip as up to date: \"none\", \"mtime\" (those whose output is newer than the input), or \"hash\" (those whose content is unchanged since the manifest was written)")
+	manifestName = flag.String("manifest", "manifest.json", "the name of the manifest describing the converted files, written to the output directory; if empty, no manifest is written")
+	workers      = flag.Int("workers", runtime.NumCPU(), "the number of files to convert concurrently")
+	verbose      = flag.Bool("verbose", false, "print each file as it is converted")
+	compress     = flag.String("compress", "none", "the compression of the converted files (compressed input files are detected automatically)")
 )
 
 const (
 	ERR_USAGE = 2 + iota
 	ERR_IO
-	ERR_SUBCOMMAND
+	ERR_CONVERT
 )
 
-var exit_code = 0
+// A manifest describes a converted dataset. It records
+// the settings used so that a later run with different
+// settings doesn't consider any file up to date.
+type manifest struct {
+	Format       string `json:",omitempty"`
+	OutputFormat string
+	Compression  string
+	Files        []*manifestEntry
+}
+
+// A manifestEntry describes a single converted
+// file. Paths are slash-separated and relative
+// to the input and output directories.
+type manifestEntry struct {
+	Source string
+	Output string
+	Format string
+	SHA256 string
+	Nodes  int
+	Links  int
+}
+
+// A job is the conversion of a single file
+type job struct {
+	from, to string
+	// The entry for the file in the previous
+	// manifest, if any, and in the new one
+	old, entry *manifestEntry
+	upToDate   bool
+	err        error
+}
 
 func main() {
 	flag.Parse()
+	encoding.Warnings = os.Stderr
+
+	if *format != "" {
+		if f, ok := encoding.Lookup(*format); !ok || f.NewDecoder == nil {
+			fmt.Fprintf(os.Stderr, "Unknown input format: %v\n", *format)
+			os.Exit(ERR_USAGE)
+		}
+	}
+	if _, err := encoding.NewFormatEncoder(*outputFormat, ioutil.Discard, encoding.Header{}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	if _, err := encoding.NewCompressor(ioutil.Discard, *compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	if *workers < 1 {
+		fmt.Fprintf(os.Stderr, "Number of workers must be positive\n")
+		os.Exit(ERR_USAGE)
+	}
+	switch *skip {
+	case "none", "mtime":
+	case "hash":
+		if *manifestName == "" {
+			fmt.Fprintf(os.Stderr, "Skipping by hash requires a manifest\n")
+			os.Exit(ERR_USAGE)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown skip mode: %v\n", *skip)
+		os.Exit(ERR_USAGE)
+	}
+	includes, excludes := patterns(*include), patterns(*exclude)
+	for _, p := range append(includes, excludes...) {
+		if _, err := path.Match(p, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad pattern %q: %v\n", p, err)
+			os.Exit(ERR_USAGE)
+		}
+	}
 
 	inFi, err := os.Stat(*input)
 	if err != nil {
@@ -38,18 +122,13 @@ func main() {
 		os.Exit(ERR_IO)
 	}
 
-	dir, err := ioutil.ReadDir(*input)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error listing input directory: %v\n", err)
-		os.Exit(ERR_IO)
-	}
 	outFi, err := os.Stat(*output)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error statting output directory: %v\n", err)
 		os.Exit(ERR_IO)
 	}
 
-	if !inFi.IsDir() {
+	if !outFi.IsDir() {
 		fmt.Fprintf(os.Stderr, "Output directory is not a directory\n")
 		os.Exit(ERR_IO)
 	}
@@ -59,41 +138,295 @@ func main() {
 		os.Exit(ERR_USAGE)
 	}
 
-	_, err = exec.LookPath("convert")
+	m := manifest{Format: *format, OutputFormat: *outputFormat, Compression: *compress}
+	old := make(map[string]*manifestEntry)
+	if *manifestName != "" {
+		prev, err := readManifest(filepath.Join(*output, *manifestName))
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Ignoring previous manifest: %v\n", err)
+		case prev.Format == m.Format && prev.OutputFormat == m.OutputFormat && prev.Compression == m.Compression:
+			// Entries are only useful if the files
+			// were converted with the same settings
+			for _, e := range prev.Files {
+				old[e.Source] = e
+			}
+		}
+	}
+
+	// Files whose names differ only in their
+	// extension would be converted to the same
+	// output file; only the first is converted
+	var jobs []*job
+	seen := make(map[string]string)
+	err = filepath.Walk(*input, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			// Don't descend into the output directory
+			// if it is inside the input directory
+			if p != *input && (!*recursive || os.SameFile(fi, outFi)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(*input, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if (len(includes) > 0 && !matches(includes, rel)) || matches(excludes, rel) {
+			return nil
+		}
+		to := path.Join(path.Dir(rel), sanitize(path.Base(rel)))
+		j := &job{
+			from:  p,
+			to:    filepath.Join(*output, filepath.FromSlash(to)),
+			old:   old[rel],
+			entry: &manifestEntry{Source: rel, Output: to},
+		}
+		if other, ok := seen[j.to]; ok {
+			j.err = fmt.Errorf("output file %v is also the output of %v", j.to, other)
+		}
+		seen[j.to] = j.from
+		jobs = append(jobs, j)
+		return nil
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error running convert: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error listing input directory: %v\n", err)
 		os.Exit(ERR_IO)
 	}
-	for _, f := range dir {
-		if !f.IsDir() {
-			from := filepath.Join(*input, f.Name())
-			to := filepath.Join(*output, sanitize(f.Name()))
-			if *verbose {
-				fmt.Println(from, "->", to)
+
+	queue := make(chan *job)
+	var wg sync.WaitGroup
+	var mu sync.Mutex // serializes progress output
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				run(j)
+				if *verbose {
+					mu.Lock()
+					switch {
+					case j.err != nil:
+						fmt.Println(j.from, "-> failed")
+					case j.upToDate:
+						fmt.Println(j.from, "->", j.to, "(up to date)")
+					default:
+						fmt.Println(j.from, "->", j.to)
+					}
+					mu.Unlock()
+				}
 			}
-			convert(from, to)
+		}()
+	}
+	for _, j := range jobs {
+		if j.err == nil {
+			queue <- j
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	// jobs are in the order of the directory
+	// walk, which is sorted by name
+	var failed []*job
+	upToDate := 0
+	for _, j := range jobs {
+		if j.err != nil {
+			failed = append(failed, j)
+			continue
+		}
+		if j.upToDate {
+			upToDate++
+		}
+		m.Files = append(m.Files, j.entry)
+	}
+	exitCode := 0
+	if *manifestName != "" {
+		if err := writeManifest(filepath.Join(*output, *manifestName), m); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing manifest: %v\n", err)
+			exitCode = ERR_IO
+		}
+	}
+
+	fmt.Printf("Converted %v of %v files", len(jobs)-len(failed)-upToDate, len(jobs))
+	if *skip != "none" {
+		fmt.Printf(" (%v up to date)", upToDate)
+	}
+	fmt.Println()
+	if len(failed) > 0 {
+		fmt.Printf("Failed:\n")
+		for _, j := range failed {
+			fmt.Printf("  %v: %v\n", j.from, j.err)
 		}
+		exitCode = ERR_CONVERT
 	}
-	os.Exit(exit_code)
+	os.Exit(exitCode)
 }
 
-func convert(from, to string) {
-	cmd := exec.Command("convert", "--input", from, "--output", to)
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+// Convert a single file unless it is up to date
+func run(j *job) {
+	if *manifestName != "" {
+		sum, err := hashFile(j.from)
+		if err != nil {
+			j.err = err
+			return
+		}
+		j.entry.SHA256 = sum
+	}
+
+	if j.isUpToDate() {
+		j.upToDate = true
+		if j.old != nil {
+			e := *j.old
+			e.SHA256 = j.entry.SHA256
+			j.entry = &e
+		}
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.to), 0755); err != nil {
+		j.err = err
+		return
+	}
+	stats, err := convert(j.from, j.to)
+	if err != nil {
+		j.err = err
+		return
+	}
+	j.entry.Format, j.entry.Nodes, j.entry.Links = stats.Format, stats.Nodes, stats.Links
+}
+
+// Whether j's output is up to date. Without a previous
+// manifest entry, the manifest can't describe the output
+// without converting it again, so it is never up to date.
+func (j *job) isUpToDate() bool {
+	if *skip == "none" || (*manifestName != "" && j.old == nil) {
+		return false
+	}
+	outFi, err := os.Stat(j.to)
+	if err != nil {
+		return false
+	}
+	if *skip == "hash" {
+		return j.old.SHA256 == j.entry.SHA256
+	}
+	inFi, err := os.Stat(j.from)
+	return err == nil && outFi.ModTime().After(inFi.ModTime())
+}
+
+// Convert a single file, removing
+// the output if conversion fails
+func convert(from, to string) (encoding.ConvertStats, error) {
+	in, err := os.Open(from)
+	if err != nil {
+		return encoding.ConvertStats{}, err
+	}
+	defer in.Close()
+	// Write to a temporary file in the same directory
+	// and only replace the output once conversion has
+	// succeeded, so that a failed reconversion leaves
+	// the previous output in place
+	out, err := ioutil.TempFile(filepath.Dir(to), "."+filepath.Base(to))
+	if err != nil {
+		return encoding.ConvertStats{}, err
+	}
+	// Temporary files are only readable by their owner;
+	// give the output the permissions of the file it
+	// replaces, if any
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(to); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	out.Chmod(mode)
+
+	c := encoding.Conversion{
+		Format:        *format,
+		DefaultFormat: "edge-list",
+		OutputFormat:  *outputFormat,
+		Compression:   *compress,
+		Header:        encoding.Header{Generator: "convert"},
+	}
+	stats, err := encoding.Convert(out, in, from, c)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), to)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return stats, err
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readManifest(filename string) (manifest, error) {
+	var m manifest
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func writeManifest(filename string, m manifest) error {
+	if m.Files == nil {
+		m.Files = []*manifestEntry{}
+	}
+	data, err := json.MarshalIndent(m, "", "\t")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error running \"convert\" subcommand: %v\n", err)
-		exit_code = ERR_SUBCOMMAND
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+func patterns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// Whether the slash-separated relative path
+// rel, or its base name, matches any of pats
+func matches(pats []string, rel string) bool {
+	for _, p := range pats {
+		if ok, _ := path.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
 	}
+	return false
 }
 
 // assumes filename is a base
 // (that is, does not include
 // other path components)
 func sanitize(filename string) string {
-	parts := strings.Split(filename, ".")
+	parts := strings.Split(encoding.TrimCompressionExt(filename), ".")
 	if len(parts) > 1 {
 		parts = parts[:len(parts)-1]
 	}
-	return strings.Join(append(parts, "def"), ".")
+	f, _ := encoding.Lookup(*outputFormat)
+	return strings.Join(parts, ".") + f.Extensions[0] + encoding.CompressionExt(*compress)
 }
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/synful/cluster-simulate/graph"
@@ -23,21 +22,101 @@ const (
 var (
 	inputFilename  = flag.String("input", "-", "the file to convert")
 	outputFilename = flag.String("output", "-", "the destination to write the converted graph")
-	inputFormat    = flag.String("format", "edge-list", "the format of the input file; currently only supports \"edge-list\".")
-)
+	inputFormat    = flag.String("format", "", "the format of the input file: "+strings.Join(encoding.ReadableFormats(), ", ")+"; if empty, it is detected from the file's content or extension, defaulting to edge-list")
+	outputFormat   = flag.String("outputFormat", "", "the format of the output file: "+strings.Join(encoding.WritableFormats(), ", ")+"; if empty, it is inferred from the output file's extension, defaulting to json")
+	compress       = flag.String("compress", "none", "the compression of the output file: "+strings.Join(encoding.Compressions, " or ")+" (compressed input is detected automatically)")
 
-var converters = map[string]func(*bytes.Buffer) (graph.GraphDef, error){
-	"edge-list": edgeListConverter,
-}
+	// Edge lists
+	clustersFilename = flag.String("clusters", "", "a file assigning nodes in an edge list to clusters (each line giving a node ID and a cluster ID)")
+	stream           = flag.Bool("stream", false, "convert an edge list using bounded memory (sorting links on disk if necessary); useful for very large graphs; not supported for output formats written all at once (graphml)")
+	chunkSize        = flag.Int("chunkSize", 1<<22, "with -stream, the number of links to sort in memory at once")
+
+	// Topology Zoo
+	costFrom     = flag.String("costFrom", "speed", "how to derive link costs for the zoo format: \"speed\" (reference bandwidth divided by link speed), \"distance\" (km), or \"hops\"")
+	refBandwidth = flag.Float64("refBandwidth", 100e9, "the reference bandwidth (in bits/s) used to derive link costs from speeds")
+
+	// Rocketfuel
+	weightsFilename = flag.String("weights", "", "a Rocketfuel weights file giving the link weights between locations")
+
+	// CAIDA
+	clusterBy      = flag.String("clusterBy", "", "how to assign the starting clusters of ASes in the caida format: \"org\" (by organization; requires -as2org) or \"cone\" (by customer cone)")
+	as2orgFilename = flag.String("as2org", "", "a CAIDA as2org file mapping ASes to organizations")
+
+	// Preprocessing
+	dedup            = flag.Bool("dedup", false, "remove self-links and duplicate nodes and links (keeping the last definition of each)")
+	largestComponent = flag.Bool("largestComponent", false, "keep only the largest connected component")
+	minComponentSize = flag.Int("minComponentSize", 0, "remove connected components with fewer nodes")
+	relabelFilename  = flag.String("relabel", "", "relabel nodes with compact integer IDs, writing the original IDs to the given label file")
+	restoreFilename  = flag.String("restoreLabels", "", "a label file (as written by -relabel) giving the original IDs to restore")
+)
 
 func main() {
 	flag.Parse()
+	encoding.Warnings = os.Stderr
+
+	if *chunkSize < 1 {
+		fmt.Fprintf(os.Stderr, "Chunk size must be positive\n")
+		os.Exit(ERR_FORMAT)
+	}
+
+	switch *costFrom {
+	case "speed", "distance", "hops":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cost source: %v\n", *costFrom)
+		os.Exit(ERR_FORMAT)
+	}
+
+	switch *clusterBy {
+	case "", "org", "cone":
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown clustering: %v\n", *clusterBy)
+		os.Exit(ERR_FORMAT)
+	}
+
+	// "def" is accepted for compatibility; it
+	// means any format which can be detected
+	if *inputFormat == "def" {
+		*inputFormat = ""
+	}
+	if *inputFormat != "" {
+		if f, ok := encoding.Lookup(*inputFormat); !ok || f.NewDecoder == nil {
+			fmt.Fprintf(os.Stderr, "Unknown format: %v\n", *inputFormat)
+			os.Exit(ERR_FORMAT)
+		}
+	}
+	if *outputFormat == "" {
+		*outputFormat = "json"
+		if f, ok := encoding.ByExtension(*outputFilename); ok && f.NewEncoder != nil {
+			*outputFormat = f.Name
+		}
+	}
+	if _, err := encoding.NewFormatEncoder(*outputFormat, ioutil.Discard, encoding.Header{}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_FORMAT)
+	}
+	if _, err := encoding.NewCompressor(ioutil.Discard, *compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_FORMAT)
+	}
 
-	converter, ok := converters[*inputFormat]
-	if !ok {
-		fmt.Fprintf(os.Stderr, "Unknown format: %v\n", *inputFormat)
+	p, preprocess := preprocessing()
+	if preprocess && *stream {
+		fmt.Fprintf(os.Stderr, "Preprocessing is not supported with -stream\n")
 		os.Exit(ERR_FORMAT)
 	}
+	if *restoreFilename != "" {
+		f, err := os.Open(*restoreFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening label file: %v\n", err)
+			os.Exit(ERR_IO)
+		}
+		p.Rename, err = encoding.ReadLabels(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading label file: %v\n", err)
+			os.Exit(ERR_PARSE)
+		}
+	}
 
 	var input, output *os.File
 	if *inputFilename == "-" {
@@ -53,86 +132,147 @@ func main() {
 	if *outputFilename == "-" {
 		output = os.Stdout
 	} else {
+		// Write to a temporary file in the same directory
+		// and only replace the output once conversion has
+		// succeeded, so that bad input (which may not be
+		// detected until well into the conversion) doesn't
+		// clobber an existing output file
 		var err error
-		output, err = os.Create(*outputFilename)
+		output, err = ioutil.TempFile(filepath.Dir(*outputFilename), "."+filepath.Base(*outputFilename))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
 			os.Exit(ERR_IO)
 		}
+		// Temporary files are only readable by their owner;
+		// give the output the permissions of the file it
+		// replaces, if any
+		mode := os.FileMode(0644)
+		if fi, err := os.Stat(*outputFilename); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		output.Chmod(mode)
 	}
 
-	data, err := ioutil.ReadAll(input)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input file: %v\n", err)
-		os.Exit(ERR_IO)
+	c := encoding.Conversion{
+		Format:        *inputFormat,
+		DefaultFormat: "edge-list",
+		Options:       options(),
+		OutputFormat:  *outputFormat,
+		Compression:   *compress,
+		Header:        encoding.Header{Generator: "convert"},
+		Stream:        *stream,
+		Preprocess:    p,
 	}
-
-	def, err := converter(bytes.NewBuffer(data))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing input file: %v\n", err)
-		os.Exit(ERR_PARSE)
+	if preprocess {
+		c.Header.Meta = map[string]string{"preprocess": preprocessDesc()}
+	}
+	stats, err := encoding.Convert(output, input, *inputFilename, c)
+	if output != os.Stdout {
+		if cerr := output.Close(); err == nil && cerr != nil {
+			err = &encoding.ConvertError{Op: "write", Err: cerr}
+		}
+		if err == nil {
+			if rerr := os.Rename(output.Name(), *outputFilename); rerr != nil {
+				err = &encoding.ConvertError{Op: "write", Err: rerr}
+			}
+		}
+		if err != nil {
+			os.Remove(output.Name())
+		}
 	}
-
-	data, err = encoding.Marshal(def)
-
-	_, err = output.Write(data)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
-		os.Exit(ERR_IO)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		code := ERR_FORMAT
+		if cerr, ok := err.(*encoding.ConvertError); ok {
+			switch cerr.Op {
+			case "read", "write":
+				code = ERR_IO
+			default:
+				code = ERR_PARSE
+			}
+		}
+		os.Exit(code)
 	}
-}
-
-func edgeListConverter(data *bytes.Buffer) (graph.GraphDef, error) {
-	var def graph.GraphDef
-
-	nodes := make(map[graph.NodeID]graph.NodeDef)
-	links := make(map[graph.LinkDef]struct{})
 
-	r := bufio.NewReader(data)
-	s, err := r.ReadString('\n')
-	for ; err == nil || err == io.EOF; s, err = r.ReadString('\n') {
-		if err == io.EOF && len(s) == 0 {
-			break
+	if preprocess {
+		printReport(stats.Report)
+	}
+	if *relabelFilename != "" {
+		f, err := os.Create(*relabelFilename)
+		if err == nil {
+			err = encoding.WriteLabels(f, stats.Report.Labels)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
 		}
-
-		// Slice before newline character
-		s = s[:len(s)-1]
-		if len(s) == 0 {
-			continue
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing label file: %v\n", err)
+			os.Exit(ERR_IO)
 		}
+	}
+}
 
-		arr := strings.Split(s, "\t")
-		if len(arr) != 2 || arr[0] == "" || arr[1] == "" {
-			return def, fmt.Errorf("Malformed line: %q", s)
-		}
+// Collect the format options given by flags
+func options() encoding.Options {
+	return encoding.Options{
+		"clusters":     *clustersFilename,
+		"chunkSize":    strconv.Itoa(*chunkSize),
+		"costFrom":     *costFrom,
+		"refBandwidth": strconv.FormatFloat(*refBandwidth, 'g', -1, 64),
+		"weights":      *weightsFilename,
+		"clusterBy":    *clusterBy,
+		"as2org":       *as2orgFilename,
+	}
+}
 
-		a, b := graph.NodeID(arr[0]), graph.NodeID(arr[1])
-		if a == b {
-			fmt.Fprintf(os.Stderr, "Omitting illegal self-link for node %v\n", a)
-			// return def, fmt.Errorf("Illegal self-link for node %v", a)
-		}
-		nodes[a] = graph.NodeDef{a, graph.ClusterID(a)}
-		nodes[b] = graph.NodeDef{b, graph.ClusterID(b)}
-		// Make sure only one link is created per edge
-		if a < b {
-			links[graph.LinkDef{a, b, 1}] = struct{}{}
-		}
+// Collect the preprocessing given by flags, and
+// whether any was requested
+func preprocessing() (graph.Preprocessing, bool) {
+	p := graph.Preprocessing{
+		DropSelfLinks:    *dedup,
+		DropDuplicates:   *dedup,
+		LargestComponent: *largestComponent,
+		MinComponentSize: *minComponentSize,
+		Relabel:          *relabelFilename != "",
+	}
+	return p, *dedup || *largestComponent || *minComponentSize > 0 || *relabelFilename != "" || *restoreFilename != ""
+}
 
-		if err == io.EOF {
-			break
-		}
+// Describe the preprocessing given by
+// flags for the output's metadata
+func preprocessDesc() string {
+	var steps []string
+	if *restoreFilename != "" {
+		steps = append(steps, "restoreLabels")
+	}
+	if *dedup {
+		steps = append(steps, "dedup")
+	}
+	if *largestComponent {
+		steps = append(steps, "largestComponent")
 	}
-	if err != io.EOF {
-		panic(fmt.Sprintf("Got non-EOF error: %v", err))
+	if *minComponentSize > 0 {
+		steps = append(steps, fmt.Sprintf("minComponentSize=%v", *minComponentSize))
 	}
+	if *relabelFilename != "" {
+		steps = append(steps, "relabel")
+	}
+	return strings.Join(steps, ",")
+}
 
-	def.Nodes = make([]graph.NodeDef, 0, len(nodes))
-	def.Links = make([]graph.LinkDef, 0, len(links))
-	for _, n := range nodes {
-		def.Nodes = append(def.Nodes, n)
+func printReport(r graph.PreprocessReport) {
+	fmt.Fprintf(os.Stderr, "Preprocessing:\n")
+	if *restoreFilename != "" {
+		fmt.Fprintf(os.Stderr, "  Restored original IDs from %v\n", *restoreFilename)
+	}
+	if *dedup {
+		fmt.Fprintf(os.Stderr, "  Removed %v self-links\n", r.SelfLinks)
+		fmt.Fprintf(os.Stderr, "  Removed %v duplicate nodes and %v duplicate links\n", r.DuplicateNodes, r.DuplicateLinks)
+	}
+	if *largestComponent || *minComponentSize > 0 {
+		fmt.Fprintf(os.Stderr, "  Removed %v of %v components (%v nodes and %v links)\n", r.RemovedComponents, r.Components, r.RemovedNodes, r.RemovedLinks)
 	}
-	for l, _ := range links {
-		def.Links = append(def.Links, l)
+	if *relabelFilename != "" {
+		fmt.Fprintf(os.Stderr, "  Relabeled %v nodes\n", len(r.Labels))
 	}
-	return def, nil
 }
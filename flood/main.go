@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/encoding"
+)
+
+const (
+	ERR_USAGE = 2 + iota
+	ERR_IO
+	ERR_PARSE
+	ERR_SIMULATE
+)
+
+var (
+	graphFilename = flag.String("graph", "", "a file containing the clustered graph to simulate")
+	link          = flag.String("link", "", "the link which changes, given as two comma-separated node IDs")
+	down          = flag.Bool("down", false, "simulate the failure of the link")
+	cost          = flag.Uint64("cost", 1, "the new cost of the link (unless -down is given)")
+	latencyUnit   = flag.Duration("latencyUnit", time.Millisecond, "the latency of a link per unit of cost")
+	latencyAttr   = flag.String("latencyAttr", "", "a link attribute holding link latencies (links without it fall back to -latencyUnit)")
+	spfDelay      = flag.Duration("spfDelay", 0, "the delay between receiving a new LSA and running SPF")
+	spfHold       = flag.Duration("spfHold", 0, "the minimum time between consecutive SPF runs")
+	spfTime       = flag.Duration("spfTime", 0, "the time an SPF run takes")
+	nodes         = flag.Bool("nodes", false, "show the time to converge of every affected node")
+)
+
+func main() {
+	flag.Parse()
+	encoding.Warnings = os.Stderr
+
+	if *graphFilename == "" {
+		fmt.Fprintf(os.Stderr, "No graph file specified\n")
+		os.Exit(ERR_USAGE)
+	}
+	ends := strings.Split(*link, ",")
+	if len(ends) != 2 || ends[0] == "" || ends[1] == "" {
+		fmt.Fprintf(os.Stderr, "Bad link: %q\n", *link)
+		os.Exit(ERR_USAGE)
+	}
+
+	f, err := os.Open(*graphFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening graph file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+
+	d, err := encoding.NewFormatDecoder("", f, *graphFilename, nil)
+	var g *graph.Graph
+	if err == nil {
+		g, err = encoding.ReadGraph(d, graph.MaxCost)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing graph file: %v\n", err)
+		os.Exit(ERR_PARSE)
+	}
+	f.Close()
+
+	latency := graph.CostLatency(*latencyUnit)
+	if *latencyAttr != "" {
+		latency = graph.AttrLatency(*latencyAttr, latency)
+	}
+	cfg := graph.FloodConfig{
+		Latency:  latency,
+		SPFDelay: *spfDelay,
+		SPFHold:  *spfHold,
+		SPFTime:  *spfTime,
+	}
+	change := graph.LinkChange{
+		A:    graph.NodeID(ends[0]),
+		B:    graph.NodeID(ends[1]),
+		Down: *down,
+		Cost: *cost,
+	}
+
+	res, err := g.SimulateFlood(change, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error simulating flooding: %v\n", err)
+		os.Exit(ERR_SIMULATE)
+	}
+
+	var total time.Duration
+	for _, t := range res.ConvergeTime {
+		total += t
+	}
+	fmt.Printf("Messages: %v (%v local, %v overlay)\n", res.Messages, res.LocalMessages, res.OverlayMessages)
+	fmt.Printf("Nodes updated: %v of %v\n", len(res.ConvergeTime), g.NumNodes())
+	fmt.Printf("Time to converge: %v\n", res.Converged)
+	if len(res.ConvergeTime) > 0 {
+		fmt.Printf("Average time to converge per updated node: %v\n", total/time.Duration(len(res.ConvergeTime)))
+	}
+
+	if *nodes {
+		fmt.Println()
+		ids := make([]string, 0, len(res.ConvergeTime))
+		for n := range res.ConvergeTime {
+			ids = append(ids, string(n))
+		}
+		sort.Strings(ids)
+		for _, n := range ids {
+			fmt.Printf("  %v: %v\n", n, res.ConvergeTime[graph.NodeID(n)])
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/encoding"
+	"github.com/synful/cluster-simulate/graph/generate"
+)
+
+const (
+	ERR_USAGE = 2 + iota
+	ERR_IO
+)
+
+var models = []string{"er", "ba", "waxman", "grid", "torus", "ring", "tree", "fat-tree", "clos", "isp"}
+
+var (
+	model          = flag.String("model", "", "the model to generate: "+strings.Join(models, ", "))
+	outputFilename = flag.String("output", "-", "the destination to write the generated graph")
+	outputFormat   = flag.String("outputFormat", "", "the format of the output file: "+strings.Join(encoding.WritableFormats(), ", ")+"; if empty, it is inferred from the output file's extension, defaulting to json")
+	compress       = flag.String("compress", "none", "the compression of the output file: "+strings.Join(encoding.Compressions, " or "))
+	seed           = flag.Int64("seed", 0, "the seed of the random number generator")
+	cost           = flag.String("cost", "1", "the distribution of link costs: a constant, \"uniform:LO-HI\", \"exp:MEAN\", or \"distance[:SCALE]\" (for models with coordinates: waxman, grid, torus, isp)")
+	clusters       = flag.String("clusters", "none", "the initial clusters: \"none\" (a cluster per node), \"random:K\", \"blocks:K\", or \"group\" (by tree subtree, fat-tree pod, clos tier, or isp PoP)")
+
+	// Size parameters
+	n      = flag.Int("n", 100, "the number of nodes (er, ba, waxman, ring)")
+	p      = flag.Float64("p", 0.05, "the link probability (er)")
+	m      = flag.Int("m", 2, "the number of links added with each node (ba)")
+	alpha  = flag.Float64("alpha", 0.15, "the Waxman alpha parameter; larger values make long links more likely (waxman)")
+	beta   = flag.Float64("beta", 0.4, "the Waxman beta parameter; larger values make all links more likely (waxman)")
+	rows   = flag.Int("rows", 10, "the number of rows (grid, torus)")
+	cols   = flag.Int("cols", 10, "the number of columns (grid, torus)")
+	depth  = flag.Int("depth", 3, "the depth of the tree (tree)")
+	fanout = flag.Int("fanout", 3, "the number of children of each internal node (tree)")
+	k      = flag.Int("k", 4, "the arity of the fat-tree; must be even (fat-tree)")
+	leaves = flag.Int("leaves", 8, "the number of leaf switches (clos)")
+	spines = flag.Int("spines", 4, "the number of spine switches (clos)")
+	pops   = flag.Int("pops", 10, "the number of points of presence (isp)")
+	access = flag.Int("access", 4, "the number of access routers in each point of presence (isp)")
+)
+
+func main() {
+	flag.Parse()
+
+	costDist, err := generate.ParseCostDist(*cost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	clustering, err := generate.ParseClustering(*clusters)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	cfg := generate.Config{Seed: *seed, Cost: costDist, Clusters: clustering}
+
+	// The parameters used, recorded in the header
+	params := map[string]interface{}{}
+	var def graph.GraphDef
+	switch *model {
+	case "er":
+		params["n"], params["p"] = *n, *p
+		def, err = generate.ErdosRenyi(*n, *p, cfg)
+	case "ba":
+		params["n"], params["m"] = *n, *m
+		def, err = generate.BarabasiAlbert(*n, *m, cfg)
+	case "waxman":
+		params["n"], params["alpha"], params["beta"] = *n, *alpha, *beta
+		def, err = generate.Waxman(*n, *alpha, *beta, cfg)
+	case "grid", "torus":
+		params["rows"], params["cols"] = *rows, *cols
+		def, err = generate.Grid(*rows, *cols, *model == "torus", cfg)
+	case "ring":
+		params["n"] = *n
+		def, err = generate.Ring(*n, cfg)
+	case "tree":
+		params["depth"], params["fanout"] = *depth, *fanout
+		def, err = generate.Tree(*depth, *fanout, cfg)
+	case "fat-tree":
+		params["k"] = *k
+		def, err = generate.FatTree(*k, cfg)
+	case "clos":
+		params["leaves"], params["spines"] = *leaves, *spines
+		def, err = generate.Clos(*leaves, *spines, cfg)
+	case "isp":
+		params["pops"], params["access"] = *pops, *access
+		def, err = generate.ISP(*pops, *access, cfg)
+	case "":
+		fmt.Fprintf(os.Stderr, "No model specified\n")
+		os.Exit(ERR_USAGE)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown model: %v\n", *model)
+		os.Exit(ERR_USAGE)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating graph: %v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+
+	if *outputFormat == "" {
+		*outputFormat = "json"
+		if f, ok := encoding.ByExtension(*outputFilename); ok && f.NewEncoder != nil {
+			*outputFormat = f.Name
+		}
+	}
+	if _, err := encoding.NewFormatEncoder(*outputFormat, ioutil.Discard, encoding.Header{}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	if _, err := encoding.NewCompressor(ioutil.Discard, *compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+
+	output := os.Stdout
+	if *outputFilename != "-" {
+		output, err = os.Create(*outputFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
+			os.Exit(ERR_IO)
+		}
+	}
+
+	h := encoding.Header{
+		Generator: "generate",
+		Meta: map[string]string{
+			"model":    *model,
+			"seed":     fmt.Sprint(*seed),
+			"cost":     *cost,
+			"clusters": *clusters,
+		},
+	}
+	for name, v := range params {
+		h.Meta[name] = fmt.Sprint(v)
+	}
+	w, _ := encoding.NewCompressor(output, *compress)
+	enc, _ := encoding.NewFormatEncoder(*outputFormat, w, h)
+	err = encoding.Encode(enc, def.Canonical())
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+}
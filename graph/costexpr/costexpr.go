@@ -0,0 +1,429 @@
+// Package costexpr compiles arithmetic expressions over
+// cluster metrics into cost functions, so that new costs
+// can be tried without modifying the graph package.
+//
+// An expression may use numbers, the operators +, -, *,
+// / and ^ (exponentiation), parentheses, the functions
+// listed in Functions, and the metrics listed in Metrics
+// (each of which may be referred to by its full or short
+// name). For example:
+//
+//	max(local, remote) + 0.5*borderNodes
+//
+// Text from a # to the end of the line is a comment.
+package costexpr
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// A Metric is a property of a cluster
+// which may be used in an expression.
+type Metric struct {
+	Name, Short string
+	eval        func(g *graph.Graph, c *graph.Cluster) int
+}
+
+const numMetrics = 6
+
+// The values of the metrics used by an expression
+// (indexed in the same order as Metrics)
+type metricValues [numMetrics]float64
+
+// Metrics lists the cluster metrics
+// available to expressions.
+var Metrics = [numMetrics]Metric{
+	{"LocalLSDBSize", "local", func(g *graph.Graph, c *graph.Cluster) int { return c.LocalLSDBSize() }},
+	{"RemoteLSDBSize", "remote", func(g *graph.Graph, c *graph.Cluster) int { return g.RemoteLSDBSize(c.ClusterID()) }},
+	{"NumNodes", "nodes", func(g *graph.Graph, c *graph.Cluster) int { return c.NumNodes() }},
+	{"NumBorderNodes", "borderNodes", func(g *graph.Graph, c *graph.Cluster) int { return c.NumBorderNodes() }},
+	{"NumBorderEdges", "borderEdges", func(g *graph.Graph, c *graph.Cluster) int { return c.NumBorderEdges() }},
+	{"NumEdges", "edges", func(g *graph.Graph, c *graph.Cluster) int { return c.NumEdges() }},
+}
+
+type function struct {
+	// Minimum and maximum number of arguments
+	// (max < 0 means no maximum)
+	min, max int
+	f        func(args []float64) float64
+}
+
+// Functions lists the names of the functions
+// available to expressions.
+var Functions = []string{"max", "min", "abs", "sqrt", "log", "log2", "floor", "ceil"}
+
+var functions = map[string]function{
+	"max": {1, -1, func(args []float64) float64 {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m
+	}},
+	"min": {1, -1, func(args []float64) float64 {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m
+	}},
+	"abs":   {1, 1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"sqrt":  {1, 1, func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"log":   {1, 1, func(args []float64) float64 { return math.Log(args[0]) }},
+	"log2":  {1, 1, func(args []float64) float64 { return math.Log2(args[0]) }},
+	"floor": {1, 1, func(args []float64) float64 { return math.Floor(args[0]) }},
+	"ceil":  {1, 1, func(args []float64) float64 { return math.Ceil(args[0]) }},
+}
+
+// Limit is the largest magnitude of a cost computed by
+// an expression. Results beyond it are clamped to it,
+// and results which aren't numbers (from dividing by
+// zero, or taking the log or square root of zero or a
+// negative number) are treated as Limit, so that clusters
+// whose cost is undefined are never preferred. Limit is
+// small enough that costs can be summed without overflow.
+const Limit = math.MaxInt32
+
+// Compile parses the expression src and returns a
+// graph.Cost which evaluates it, rounding the result
+// to the nearest integer (see Limit).
+func Compile(src string) (graph.Cost, error) {
+	p := &parser{src: src}
+	p.next()
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %v", p.tok)
+	}
+
+	// Only compute the metrics which are
+	// used, and only once per evaluation
+	used := p.used
+	return func(g *graph.Graph, cid graph.ClusterID) int {
+		c := g.Cluster(cid)
+		var vals metricValues
+		for i, m := range Metrics {
+			if used[i] {
+				vals[i] = float64(m.eval(g, c))
+			}
+		}
+		return round(e(&vals))
+	}, nil
+}
+
+// Round x to the nearest integer,
+// saturating it as described by Limit
+func round(x float64) int {
+	switch {
+	case math.IsNaN(x) || math.IsInf(x, 0):
+		return Limit
+	case x >= Limit:
+		return Limit
+	case x <= -Limit:
+		return -Limit
+	}
+	return int(math.Floor(x + 0.5))
+}
+
+// Load returns the cost function defined by the
+// given expression, or, if expr is empty, by the
+// contents of the given file. If both are empty,
+// it returns graph.MaxCost. The returned string
+// describes the cost function.
+func Load(expr, filename string) (graph.Cost, string, error) {
+	switch {
+	case expr != "" && filename != "":
+		return nil, "", fmt.Errorf("cannot specify both a cost expression and a cost file")
+	case expr == "" && filename == "":
+		return graph.MaxCost, "MaxCost", nil
+	case filename != "":
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, "", err
+		}
+		expr = string(data)
+	}
+	cost, err := Compile(expr)
+	if err != nil {
+		return nil, "", err
+	}
+	return cost, describe(expr), nil
+}
+
+// Strip comments and collapse
+// whitespace in expr
+func describe(expr string) string {
+	var lines []string
+	for _, line := range strings.Split(expr, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(strings.Fields(strings.Join(lines, " ")), " ")
+}
+
+/*
+	PARSER
+*/
+
+type expr func(vals *metricValues) float64
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNum
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type parser struct {
+	src  string
+	pos  int
+	tok  token
+	used [numMetrics]bool
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("column %v: %v", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// Advance p.tok to the next token
+func (p *parser) next() {
+	// Skip whitespace and comments
+skip:
+	for p.pos < len(p.src) {
+		switch r := rune(p.src[p.pos]); {
+		case unicode.IsSpace(r):
+			p.pos++
+		case r == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			break skip
+		}
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{tokEOF, "", start}
+		return
+	}
+
+	isIdent := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	isNum := func(r rune) bool { return r == '.' || unicode.IsDigit(r) }
+	r := rune(p.src[p.pos])
+	switch {
+	case isNum(r):
+		for p.pos < len(p.src) && isNum(rune(p.src[p.pos])) {
+			p.pos++
+		}
+		// Exponent (e.g. "1e6")
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && unicode.IsDigit(rune(p.src[p.pos])) {
+				p.pos++
+			}
+		}
+		p.tok = token{tokNum, p.src[start:p.pos], start}
+	case r == '_' || unicode.IsLetter(r):
+		for p.pos < len(p.src) && isIdent(rune(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{tokIdent, p.src[start:p.pos], start}
+	default:
+		p.pos++
+		p.tok = token{tokOp, p.src[start:p.pos], start}
+	}
+}
+
+func (p *parser) isOp(ops string) bool {
+	return p.tok.kind == tokOp && strings.Contains(ops, p.tok.text)
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %q; got %v", op, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+// expr := term (('+' | '-') term)*
+func (p *parser) parseExpr() (expr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+-") {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "+" {
+			left = func(v *metricValues) float64 { return l(v) + right(v) }
+		} else {
+			left = func(v *metricValues) float64 { return l(v) - right(v) }
+		}
+	}
+	return left, nil
+}
+
+// term := unary (('*' | '/') unary)*
+func (p *parser) parseTerm() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*/") {
+		op := p.tok.text
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if op == "*" {
+			left = func(v *metricValues) float64 { return l(v) * right(v) }
+		} else {
+			left = func(v *metricValues) float64 { return l(v) / right(v) }
+		}
+	}
+	return left, nil
+}
+
+// unary := '-' unary | power
+func (p *parser) parseUnary() (expr, error) {
+	if p.isOp("-") {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v *metricValues) float64 { return -e(v) }, nil
+	}
+	return p.parsePower()
+}
+
+// power := primary ('^' unary)?
+// (so that exponentiation is right-associative)
+func (p *parser) parsePower() (expr, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("^") {
+		return base, nil
+	}
+	p.next()
+	exp, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(v *metricValues) float64 { return math.Pow(base(v), exp(v)) }, nil
+}
+
+// primary := number | metric | function '(' args ')' | '(' expr ')'
+func (p *parser) parsePrimary() (expr, error) {
+	switch tok := p.tok; {
+	case tok.kind == tokNum:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", tok.text)
+		}
+		p.next()
+		return func(v *metricValues) float64 { return n }, nil
+	case tok.kind == tokIdent:
+		p.next()
+		if p.isOp("(") {
+			return p.parseCall(tok)
+		}
+		for i, m := range Metrics {
+			if tok.text == m.Name || tok.text == m.Short {
+				p.used[i] = true
+				i := i
+				return func(v *metricValues) float64 { return v[i] }, nil
+			}
+		}
+		return nil, fmt.Errorf("column %v: unknown metric %q", tok.pos+1, tok.text)
+	case p.isOp("("):
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	default:
+		return nil, p.errorf("unexpected %v", tok)
+	}
+}
+
+func (p *parser) parseCall(name token) (expr, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("column %v: unknown function %q", name.pos+1, name.text)
+	}
+	// Consume '('
+	p.next()
+
+	var args []expr
+	if !p.isOp(")") {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, fmt.Errorf("column %v: wrong number of arguments to %v: %v", name.pos+1, name.text, len(args))
+	}
+	return func(v *metricValues) float64 {
+		vals := make([]float64, len(args))
+		for i, arg := range args {
+			vals[i] = arg(v)
+		}
+		return fn.f(vals)
+	}, nil
+}
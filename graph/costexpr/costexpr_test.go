@@ -0,0 +1,86 @@
+package costexpr
+
+import (
+	"testing"
+
+	. "github.com/synful/cluster-simulate/graph"
+)
+
+func makeTestGraph() *Graph {
+	/*
+		  B
+		 / \
+		A---C
+		    |
+		E---D
+		 \ /
+		  F
+	*/
+	def := GraphDef{
+		Nodes: []NodeDef{
+			NodeDef{ID: "A", Cluster: "C1"},
+			NodeDef{ID: "B", Cluster: "C1"},
+			NodeDef{ID: "C", Cluster: "C1"},
+			NodeDef{ID: "D", Cluster: "C2"},
+			NodeDef{ID: "E", Cluster: "C2"},
+			NodeDef{ID: "F", Cluster: "C3"},
+		},
+		Links: []LinkDef{
+			LinkDef{A: "A", B: "B", Cost: 1},
+			LinkDef{A: "B", B: "C", Cost: 2},
+			LinkDef{A: "C", B: "A", Cost: 3},
+			LinkDef{A: "C", B: "D", Cost: 4},
+			LinkDef{A: "D", B: "E", Cost: 5},
+			LinkDef{A: "E", B: "F", Cost: 6},
+			LinkDef{A: "F", B: "D", Cost: 7},
+		},
+	}
+
+	return NewGraph(def, MaxCost)
+}
+
+func TestCompile(t *testing.T) {
+	g := makeTestGraph()
+
+	// C2: 2 nodes, 2 border nodes, 3 border edges,
+	// 1 edge; local LSDB 1, remote LSDB 3
+	tests := []struct {
+		src  string
+		cost int
+	}{
+		{"max(local, remote)", MaxCost(g, "C2")},
+		{"max(LocalLSDBSize, RemoteLSDBSize)", MaxCost(g, "C2")},
+		{"max(local, remote) + 0.5*borderNodes", 4},
+		{"nodes + borderEdges * edges", 5},
+		{"(nodes + borderEdges) * edges", 5},
+		{"-nodes + 2^3^2 / 64", 6},
+		{"sqrt(remote + 1) # a comment\n * 2", 4},
+		{"min(3, 2, 1.4)", 1},
+		{"2.5", 3},
+		// Undefined and out-of-range results
+		{"nodes / (borderEdges - 3)", Limit},
+		{"-nodes / (borderEdges - 3)", Limit},
+		{"0 / 0", Limit},
+		{"log(0)", Limit},
+		{"sqrt(-1)", Limit},
+		{"10^30", Limit},
+		{"-10^30", -Limit},
+	}
+	for _, test := range tests {
+		cost, err := Compile(test.src)
+		if err != nil {
+			t.Errorf("Error compiling %q: %v", test.src, err)
+			continue
+		}
+		if c := cost(g, "C2"); c != test.cost {
+			t.Errorf("Expected %q to evaluate to %v; got %v", test.src, test.cost, c)
+		}
+	}
+
+	bad := []string{"", "local +", "foo", "max(", "max()", "sqrt(1, 2)", "bar(1)", "(local", "local remote", "1 $ 2"}
+	for _, src := range bad {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Expected error compiling %q", src)
+		}
+	}
+}
@@ -1,13 +1,26 @@
 package graph
 
+import (
+	"fmt"
+	"sort"
+)
+
 type NodeDef struct {
 	ID      NodeID
 	Cluster ClusterID
+	// Arbitrary attributes (such as
+	// coordinates) which are carried
+	// along with the node.
+	Attrs map[string]string `json:",omitempty"`
 }
 
 type LinkDef struct {
 	A, B NodeID
 	Cost uint64
+	// Arbitrary attributes (such as
+	// latency or capacity) which are
+	// carried along with the link.
+	Attrs map[string]string `json:",omitempty"`
 }
 
 // The GraphDef type provides a simple data structure to
@@ -24,75 +37,204 @@ type GraphDef struct {
 // the definition of a graph. Canonically, def should not
 // include both directions of any given link (that is,
 // a->b and b->a), though it will still behave properly
-// if such duplication exists.
+// if such duplication exists. Every link's endpoints
+// must be defined in def.Nodes.
 func NewGraph(def GraphDef, c Cost) *Graph {
-	g := &Graph{
+	b := NewBuilder(c)
+	for _, n := range def.Nodes {
+		b.AddNode(n)
+	}
+	for _, l := range def.Links {
+		if err := b.AddLink(l); err != nil {
+			panic(fmt.Sprintf("graph: %v", err))
+		}
+	}
+	return b.Graph()
+}
+
+// A Builder constructs a Graph incrementally, which
+// avoids having to hold an entire GraphDef in memory.
+type Builder struct {
+	g *Graph
+}
+
+// NewBuilder returns a Builder for an empty
+// graph which will use the given cost function.
+func NewBuilder(c Cost) *Builder {
+	return &Builder{&Graph{
 		nodes:    newGraphNodeMap(),
 		clusters: newGraphClusterMap(),
 		costFn:   c,
-	}
+	}}
+}
 
-	for _, n := range def.Nodes {
-		c, ok := g.clusters.Get(n.Cluster)
-		if !ok {
-			c = &Cluster{
-				id:      n.Cluster,
-				members: newMemberNodeMap(),
-			}
-			g.clusters.Add(n.Cluster, c)
+// AddNode adds the node defined by n
+// (replacing any node with the same ID, which
+// is removed from its cluster).
+func (b *Builder) AddNode(n NodeDef) {
+	g := b.g
+	if old, ok := g.nodes.Get(n.ID); ok {
+		old.cluster.members.Delete(n.ID)
+		if old.cluster.members.Len() == 0 {
+			g.clusters.Delete(old.cluster.id)
 		}
-		g.nodes.Add(n.ID, &Node{
-			id:      n.ID,
-			cluster: c,
-			edges:   newEdgeMap(),
-		})
-		node, _ := g.nodes.Get(n.ID)
-		c.members.Add(n.ID, node)
 	}
+	c, ok := g.clusters.Get(n.Cluster)
+	if !ok {
+		c = newCluster(n.Cluster)
+		g.clusters.Add(n.Cluster, c)
+	}
+	node := &Node{
+		id:      n.ID,
+		cluster: c,
+		edges:   newEdgeMap(),
+		attrs:   n.Attrs,
+	}
+	g.nodes.Add(n.ID, node)
+	c.members.Add(n.ID, node)
+}
 
-	for _, l := range def.Links {
-		a, _ := g.nodes.Get(l.A)
-		b, _ := g.nodes.Get(l.B)
-		a.edges.Add(l.B, edge{
-			cost: l.Cost,
-			dst:  b,
-		})
-		b.edges.Add(l.A, edge{
-			cost: l.Cost,
-			dst:  a,
-		})
+// AddLink adds the link defined by l. Both of l's
+// endpoints must already have been added.
+func (b *Builder) AddLink(l LinkDef) error {
+	a, ok := b.g.nodes.Get(l.A)
+	if !ok {
+		return fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, l.A)
+	}
+	bn, ok := b.g.nodes.Get(l.B)
+	if !ok {
+		return fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, l.B)
 	}
-	return g
+	a.edges.Add(l.B, edge{
+		cost:  l.Cost,
+		dst:   bn,
+		attrs: l.Attrs,
+	})
+	bn.edges.Add(l.A, edge{
+		cost:  l.Cost,
+		dst:   a,
+		attrs: l.Attrs,
+	})
+	return nil
 }
 
-// GraphDef creates a canonincal GraphDef describing g.
-func (g *Graph) GraphDef() GraphDef {
-	links := make(map[LinkDef]struct{})
-	for _, n := range g.nodes {
-		for _, e := range n.edges {
-			if n.id < e.dst.id {
-				links[LinkDef{
-					A:    n.id,
-					B:    e.dst.id,
-					Cost: e.cost,
-				}] = struct{}{}
-			}
+// Graph returns the graph which has been built.
+// The Builder should not be used afterwards.
+func (b *Builder) Graph() *Graph {
+	return b.g
+}
+
+// Canonical returns an equivalent copy of def in
+// canonical form: every link has A < B, nodes are
+// sorted by ID, links are sorted by A and then B,
+// and duplicate nodes and links (in either direction)
+// are removed, keeping the last definition (as
+// NewGraph would). Identical graphs always have
+// identical canonical definitions.
+func (def GraphDef) Canonical() GraphDef {
+	nodes := make(nodeDefList, len(def.Nodes))
+	copy(nodes, def.Nodes)
+	sort.Stable(nodes)
+	c := GraphDef{
+		Nodes: make([]NodeDef, 0, len(nodes)),
+		Links: make([]LinkDef, 0, len(def.Links)),
+	}
+	for i, n := range nodes {
+		if i+1 < len(nodes) && nodes[i+1].ID == n.ID {
+			continue
 		}
+		c.Nodes = append(c.Nodes, n)
 	}
 
+	links := make(linkDefList, len(def.Links))
+	for i, l := range def.Links {
+		if l.B < l.A {
+			l.A, l.B = l.B, l.A
+		}
+		links[i] = l
+	}
+	sort.Stable(links)
+	for i, l := range links {
+		if i+1 < len(links) && links[i+1].A == l.A && links[i+1].B == l.B {
+			continue
+		}
+		c.Links = append(c.Links, l)
+	}
+	return c
+}
+
+type nodeDefList []NodeDef
+
+func (n nodeDefList) Len() int           { return len(n) }
+func (n nodeDefList) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nodeDefList) Less(i, j int) bool { return n[i].ID < n[j].ID }
+
+type linkDefList []LinkDef
+
+func (l linkDefList) Len() int      { return len(l) }
+func (l linkDefList) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l linkDefList) Less(i, j int) bool {
+	if l[i].A != l[j].A {
+		return l[i].A < l[j].A
+	}
+	return l[i].B < l[j].B
+}
+
+// GraphDef creates a canonical GraphDef describing g
+// (see Canonical).
+func (g *Graph) GraphDef() GraphDef {
 	gd := GraphDef{
-		Nodes: make([]NodeDef, 0),
+		Nodes: make([]NodeDef, 0, g.nodes.Len()),
 		Links: make([]LinkDef, 0),
 	}
+	g.Walk(func(n NodeDef) error {
+		gd.Nodes = append(gd.Nodes, n)
+		return nil
+	}, func(l LinkDef) error {
+		gd.Links = append(gd.Links, l)
+		return nil
+	})
+	return gd
+}
 
-	for _, n := range g.nodes {
-		gd.Nodes = append(gd.Nodes, NodeDef{
+// Walk calls node with the definition of each of g's
+// nodes, and then calls link with the definition of
+// each of g's links (in one direction only), without
+// building an entire GraphDef. Nodes and links are
+// visited in the same order as they appear in a
+// canonical GraphDef. If either function returns an
+// error, Walk stops and returns it.
+func (g *Graph) Walk(node func(n NodeDef) error, link func(l LinkDef) error) error {
+	ids := sortedNodeIDs(g.nodes)
+	for _, id := range ids {
+		n := g.nodes[id]
+		err := node(NodeDef{
 			ID:      n.id,
 			Cluster: n.cluster.id,
+			Attrs:   n.attrs,
 		})
+		if err != nil {
+			return err
+		}
 	}
-	for l := range links {
-		gd.Links = append(gd.Links, l)
+	for _, id := range ids {
+		n := g.nodes[id]
+		for _, dst := range n.sortedNeighbors() {
+			e := n.edges[dst]
+			// Each edge is stored in both
+			// directions; only emit one.
+			if n.id < e.dst.id {
+				err := link(LinkDef{
+					A:     n.id,
+					B:     e.dst.id,
+					Cost:  e.cost,
+					Attrs: e.attrs,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
 	}
-	return gd
+	return nil
 }
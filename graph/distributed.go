@@ -0,0 +1,442 @@
+package graph
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NetworkConfig describes the simulated network
+// over which clusters exchange merge messages
+// during a distributed merge round.
+type NetworkConfig struct {
+	// Delay is the one-way delay of every message.
+	Delay time.Duration
+	// Jitter is the maximum amount of uniformly
+	// distributed random delay added to Delay.
+	Jitter time.Duration
+	// Loss is the probability, in [0, 1), that
+	// any given message is dropped.
+	Loss float64
+	// RetransmitTimeout is the amount of time
+	// a sender waits for an acknowledgement before
+	// resending a message. It is only used if Loss
+	// is nonzero (a perfect network needs neither
+	// acknowledgements nor retransmissions). If it
+	// is zero, a timeout is derived from Delay
+	// and Jitter.
+	RetransmitTimeout time.Duration
+	// Seed seeds the random number generator used
+	// to compute jitter and loss.
+	Seed int64
+}
+
+// DistributedStats describes the messages exchanged
+// during a distributed merge round.
+type DistributedStats struct {
+	// Messages is the number of messages sent,
+	// including acknowledgements, retransmissions,
+	// and messages which were lost.
+	Messages int
+	// Bytes is the total size of all sent messages.
+	Bytes int
+	// Retransmissions is the number of messages
+	// which were resent after timing out.
+	Retransmissions int
+	// Lost is the number of messages which were
+	// dropped by the network.
+	Lost int
+	// Duration is the wall-clock time it took
+	// for every cluster to make its decision.
+	Duration time.Duration
+}
+
+func (s *DistributedStats) add(t DistributedStats) {
+	s.Messages += t.Messages
+	s.Bytes += t.Bytes
+	s.Retransmissions += t.Retransmissions
+	s.Lost += t.Lost
+	s.Duration += t.Duration
+}
+
+// DistributedMergeRound is like MergeRound, except that
+// the merge protocol is run by independent actors, one per
+// cluster, which communicate only by exchanging proposal,
+// accept, and reject messages over a network simulated
+// according to cfg. Given the same graph, it performs the
+// same merges as MergeRound regardless of network conditions.
+//
+// Each actor builds its own copy of the graph from the
+// link-state database (the GraphDef describing g at the
+// start of the round), and computes its preference list
+// from that copy. Other than that, an actor knows only the
+// messages it has received. To bound memory use, only a
+// few actors hold a copy of the graph at any given time.
+//
+// If the actors fail to agree on their partners (which a
+// correct protocol never allows), an error is returned
+// and no merges are performed.
+func (g *Graph) DistributedMergeRound(cfg NetworkConfig, merge func(c, d ClusterID)) (bool, DistributedStats, error) {
+	if cfg.RetransmitTimeout == 0 {
+		cfg.RetransmitTimeout = 4*(cfg.Delay+cfg.Jitter) + 10*time.Millisecond
+	}
+	g.initHistory()
+	net := &simNetwork{
+		cfg:       cfg,
+		mailboxes: make(map[ClusterID]chan message),
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		quit:      make(chan struct{}),
+	}
+
+	lsdb := &lsdbView{
+		def:   g.GraphDef(),
+		cost:  g.costFn,
+		slots: make(chan struct{}, runtime.GOMAXPROCS(0)),
+	}
+	actors := make([]*mergeActor, 0, g.clusters.Len())
+	for c, clst := range g.Clusters() {
+		a := &mergeActor{
+			id:         c,
+			lsdb:       lsdb,
+			net:        net,
+			proposedBy: make(map[ClusterID]bool),
+			rejectedBy: make(map[ClusterID]bool),
+			unacked:    make(map[uint64]message),
+			seen:       make(map[ClusterID]map[uint64]bool),
+		}
+		// Buffer enough messages that senders
+		// rarely have to wait on a busy actor
+		net.mailboxes[c] = make(chan message, 4*(len(clst.NeighborClusters())+1))
+		actors = append(actors, a)
+	}
+
+	decisions := make(chan mergeDecision, len(actors))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, a := range actors {
+		wg.Add(1)
+		go func(a *mergeActor) {
+			a.run(decisions)
+			wg.Done()
+		}(a)
+	}
+
+	partners := make(map[ClusterID]ClusterID)
+	for range actors {
+		d := <-decisions
+		partners[d.c] = d.partner
+	}
+	stats := net.finish(start)
+	close(net.quit)
+	wg.Wait()
+
+	// Apply merges in a deterministic order
+	ids := make(clusterIDList, 0, len(partners))
+	for c := range partners {
+		ids = append(ids, c)
+	}
+	sort.Sort(ids)
+
+	for _, c := range ids {
+		if d := partners[c]; partners[d] != c {
+			return false, stats, fmt.Errorf("distributed merge actors disagree: %v chose %v, but %v chose %v", c, d, d, partners[d])
+		}
+	}
+
+	g.rounds++
+	changed := false
+	for _, c := range ids {
+		d := partners[c]
+		switch {
+		case c == d:
+			if merge != nil {
+				merge(c, c)
+			}
+		case c < d:
+			changed = true
+			if merge != nil {
+				merge(c, d)
+			}
+			g.joinClusters(c, d)
+		}
+	}
+	g.recordCosts()
+	return changed, stats, nil
+}
+
+// DistributedMergeCallback is like MergeCallback, but performs
+// rounds using DistributedMergeRound. After each round, if stats
+// is not nil, it is called with the statistics for that round.
+// It returns the number of rounds performed and the statistics
+// summed over all rounds. If a round fails, it stops and returns
+// the error.
+func (g *Graph) DistributedMergeCallback(cfg NetworkConfig, round func(), merge func(c, d ClusterID), stats func(DistributedStats)) (int, DistributedStats, error) {
+	var total DistributedStats
+	n := 0
+	if round != nil {
+		round()
+	}
+	for {
+		changed, s, err := g.DistributedMergeRound(cfg, merge)
+		total.add(s)
+		if err != nil {
+			return n, total, err
+		}
+		if stats != nil {
+			stats(s)
+		}
+		if !changed {
+			break
+		}
+		// Use a different random sequence each round
+		cfg.Seed++
+		n++
+		if round != nil {
+			round()
+		}
+	}
+	return n, total, nil
+}
+
+type clusterIDList []ClusterID
+
+func (c clusterIDList) Len() int           { return len(c) }
+func (c clusterIDList) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c clusterIDList) Less(i, j int) bool { return c[i] < c[j] }
+
+/*
+	SIMULATED NETWORK
+*/
+
+type messageType byte
+
+const (
+	msgPropose messageType = iota
+	msgAccept
+	msgReject
+	msgAck
+)
+
+type message struct {
+	typ      messageType
+	src, dst ClusterID
+	seq      uint64
+}
+
+// The size of m on the wire: a type byte,
+// an 8-byte sequence number, and both
+// length-prefixed cluster IDs.
+func (m message) size() int {
+	return 1 + 8 + 2 + len(m.src) + 2 + len(m.dst)
+}
+
+type simNetwork struct {
+	cfg       NetworkConfig
+	mailboxes map[ClusterID]chan message
+	quit      chan struct{}
+
+	// Protects rand and stats
+	mu    sync.Mutex
+	rand  *rand.Rand
+	stats DistributedStats
+}
+
+func (n *simNetwork) lossy() bool { return n.cfg.Loss > 0 }
+
+func (n *simNetwork) send(m message, retransmit bool) {
+	n.mu.Lock()
+	n.stats.Messages++
+	n.stats.Bytes += m.size()
+	if retransmit {
+		n.stats.Retransmissions++
+	}
+	if n.cfg.Loss > 0 && n.rand.Float64() < n.cfg.Loss {
+		n.stats.Lost++
+		n.mu.Unlock()
+		return
+	}
+	delay := n.cfg.Delay
+	if n.cfg.Jitter > 0 {
+		delay += time.Duration(n.rand.Int63n(int64(n.cfg.Jitter)))
+	}
+	n.mu.Unlock()
+
+	time.AfterFunc(delay, func() {
+		select {
+		case n.mailboxes[m.dst] <- m:
+		case <-n.quit:
+		}
+	})
+}
+
+func (n *simNetwork) finish(start time.Time) DistributedStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := n.stats
+	s.Duration = time.Since(start)
+	return s
+}
+
+/*
+	MERGE ACTOR
+*/
+
+type mergeDecision struct {
+	c, partner ClusterID
+}
+
+// The link-state database shared by all actors
+// of a round. It is never modified; each actor
+// builds its own Graph from it.
+type lsdbView struct {
+	def  GraphDef
+	cost Cost
+	// Limits the number of copies of
+	// the graph held at any given time
+	slots chan struct{}
+}
+
+type mergeActor struct {
+	id   ClusterID
+	lsdb *lsdbView
+	// Remaining preferences, ending with id
+	prefs     []ClusterID
+	neighbors []ClusterID
+	net       *simNetwork
+
+	proposedBy map[ClusterID]bool
+	rejectedBy map[ClusterID]bool
+	done       bool
+
+	// Reliable delivery state (only
+	// used if the network is lossy)
+	nextSeq uint64
+	unacked map[uint64]message
+	seen    map[ClusterID]map[uint64]bool
+}
+
+func (a *mergeActor) run(decisions chan<- mergeDecision) {
+	var tick <-chan time.Time
+	if a.net.lossy() {
+		t := time.NewTicker(a.net.cfg.RetransmitTimeout)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	a.computePrefs()
+	inbox := a.net.mailboxes[a.id]
+	a.advance(decisions)
+	for {
+		select {
+		case m := <-inbox:
+			a.handle(m, decisions)
+		case <-tick:
+			for _, m := range a.unacked {
+				a.net.send(m, true)
+			}
+		case <-a.net.quit:
+			return
+		}
+	}
+}
+
+// Compute a's neighbors and preferences from
+// a private copy of the graph, which is discarded
+// afterwards.
+func (a *mergeActor) computePrefs() {
+	a.lsdb.slots <- struct{}{}
+	g := NewGraph(a.lsdb.def, a.lsdb.cost)
+	// NOTE: Neighbors must be retrieved before
+	// computing preferences, which flushes the
+	// cluster's cached neighbor list.
+	a.neighbors = g.clusters[a.id].NeighborClusters()
+	a.prefs = g.proposeMerge(a.id)
+	<-a.lsdb.slots
+}
+
+func (a *mergeActor) send(typ messageType, dst ClusterID) {
+	m := message{typ: typ, src: a.id, dst: dst}
+	if a.net.lossy() {
+		a.nextSeq++
+		m.seq = a.nextSeq
+		a.unacked[m.seq] = m
+	}
+	a.net.send(m, false)
+}
+
+func (a *mergeActor) handle(m message, decisions chan<- mergeDecision) {
+	if a.net.lossy() {
+		if m.typ == msgAck {
+			delete(a.unacked, m.seq)
+			return
+		}
+		a.net.send(message{typ: msgAck, src: a.id, dst: m.src, seq: m.seq}, false)
+		if a.seen[m.src] == nil {
+			a.seen[m.src] = make(map[uint64]bool)
+		}
+		if a.seen[m.src][m.seq] {
+			// Duplicate caused by a lost ack
+			return
+		}
+		a.seen[m.src][m.seq] = true
+	}
+
+	if a.done {
+		return
+	}
+	switch m.typ {
+	case msgPropose:
+		a.proposedBy[m.src] = true
+		if a.prefs[0] == m.src {
+			a.send(msgAccept, m.src)
+			a.decide(m.src, decisions)
+		}
+	case msgAccept:
+		// Clusters only accept proposals from
+		// their first choice, and we only propose
+		// to ours, so m.src must be our first choice.
+		a.decide(m.src, decisions)
+	case msgReject:
+		a.rejectedBy[m.src] = true
+		top := a.prefs[0]
+		for i, c := range a.prefs {
+			if c == m.src {
+				a.prefs = append(a.prefs[:i:i], a.prefs[i+1:]...)
+				break
+			}
+		}
+		if top == m.src {
+			a.advance(decisions)
+		}
+	}
+}
+
+// Act on a's current first choice
+func (a *mergeActor) advance(decisions chan<- mergeDecision) {
+	top := a.prefs[0]
+	switch {
+	case top == a.id:
+		a.decide(a.id, decisions)
+	case a.proposedBy[top]:
+		a.send(msgAccept, top)
+		a.decide(top, decisions)
+	default:
+		a.send(msgPropose, top)
+	}
+}
+
+// Record a's decision and let all of
+// a's other neighbors know that a is
+// no longer available.
+func (a *mergeActor) decide(partner ClusterID, decisions chan<- mergeDecision) {
+	a.done = true
+	for _, c := range a.neighbors {
+		if c != partner && !a.rejectedBy[c] {
+			a.send(msgReject, c)
+		}
+	}
+	decisions <- mergeDecision{a.id, partner}
+}
@@ -0,0 +1,146 @@
+package graph
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DOTOptions controls the output of WriteDOT.
+type DOTOptions struct {
+	// Name is the name of the graph
+	// (defaults to "G").
+	Name string
+	// Collapse draws each cluster as a single node.
+	// Links between a pair of clusters are drawn as
+	// a single edge labeled with the lowest cost of
+	// those links and the number of links.
+	Collapse bool
+}
+
+// WriteDOT writes g to w in the Graphviz DOT language.
+// Each cluster is drawn as a subgraph (so that Graphviz
+// draws a box around it), border nodes are filled,
+// inter-cluster links are dashed, and every link is
+// labeled with its cost. Output is sorted so that
+// equal graphs produce identical output.
+func (g *Graph) WriteDOT(w io.Writer, opts DOTOptions) error {
+	if opts.Name == "" {
+		opts.Name = "G"
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "graph %v {\n", dotQuote(opts.Name))
+	if opts.Collapse {
+		g.writeDOTCollapsed(bw)
+	} else {
+		g.writeDOTClusters(bw)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+func (g *Graph) writeDOTClusters(w *bufio.Writer) {
+	w.WriteString("\tnode [shape=circle];\n")
+	for _, cid := range g.sortedClusterIDs() {
+		c := g.clusters[cid]
+		fmt.Fprintf(w, "\tsubgraph %v {\n", dotQuote("cluster_"+string(cid)))
+		fmt.Fprintf(w, "\t\tlabel=%v;\n", dotQuote(string(cid)))
+		nids := sortedNodeIDs(c.members)
+		for _, nid := range nids {
+			if c.members[nid].IsBorderNode() {
+				fmt.Fprintf(w, "\t\t%v [style=filled, fillcolor=lightgrey];\n", dotQuote(string(nid)))
+			} else {
+				fmt.Fprintf(w, "\t\t%v;\n", dotQuote(string(nid)))
+			}
+		}
+		// Intra-cluster links are drawn inside
+		// the subgraph, inter-cluster links
+		// at the top level
+		for _, nid := range nids {
+			n := c.members[nid]
+			for _, dst := range n.sortedNeighbors() {
+				e := n.edges[dst]
+				if nid < dst && e.dst.cluster == c {
+					fmt.Fprintf(w, "\t\t%v -- %v [label=\"%v\"];\n", dotQuote(string(nid)), dotQuote(string(dst)), e.cost)
+				}
+			}
+		}
+		w.WriteString("\t}\n")
+	}
+	for _, nid := range sortedNodeIDs(g.nodes) {
+		n := g.nodes[nid]
+		for _, dst := range n.sortedNeighbors() {
+			e := n.edges[dst]
+			if nid < dst && e.dst.cluster != n.cluster {
+				fmt.Fprintf(w, "\t%v -- %v [label=\"%v\", style=dashed, color=red];\n", dotQuote(string(nid)), dotQuote(string(dst)), e.cost)
+			}
+		}
+	}
+}
+
+func (g *Graph) writeDOTCollapsed(w *bufio.Writer) {
+	w.WriteString("\tnode [shape=box];\n")
+	type bundle struct {
+		minCost uint64
+		links   int
+	}
+	bundles := make(map[[2]ClusterID]*bundle)
+	// The (lexically larger) clusters
+	// bundled with each cluster
+	neighbors := make(map[ClusterID]clusterIDList)
+	cids := g.sortedClusterIDs()
+	for _, cid := range cids {
+		c := g.clusters[cid]
+		label := fmt.Sprintf("%v\n%v nodes, %v border", cid, c.NumNodes(), c.NumBorderNodes())
+		fmt.Fprintf(w, "\t%v [label=%v];\n", dotQuote(string(cid)), dotQuote(label))
+		for _, n := range c.members {
+			for _, e := range n.edges {
+				dcid := e.dst.cluster.id
+				if cid >= dcid {
+					continue
+				}
+				key := [2]ClusterID{cid, dcid}
+				b, ok := bundles[key]
+				if !ok {
+					b = &bundle{minCost: e.cost}
+					bundles[key] = b
+					neighbors[cid] = append(neighbors[cid], dcid)
+				}
+				b.links++
+				if e.cost < b.minCost {
+					b.minCost = e.cost
+				}
+			}
+		}
+	}
+	for _, a := range cids {
+		sort.Sort(neighbors[a])
+		for _, b := range neighbors[a] {
+			bd := bundles[[2]ClusterID{a, b}]
+			label := fmt.Sprint(bd.minCost)
+			if bd.links > 1 {
+				label = fmt.Sprintf("%v (%v links)", bd.minCost, bd.links)
+			}
+			fmt.Fprintf(w, "\t%v -- %v [label=%v];\n", dotQuote(string(a)), dotQuote(string(b)), dotQuote(label))
+		}
+	}
+}
+
+func (g *Graph) sortedClusterIDs() []ClusterID {
+	ids := make(clusterIDList, 0, len(g.clusters))
+	for cid := range g.clusters {
+		ids = append(ids, cid)
+	}
+	sort.Sort(ids)
+	return ids
+}
+
+// Quote s as a DOT ID
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,357 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// The binary format begins with BinaryMagic followed
+// by the format version (as a uvarint) and the header:
+//
+//	generator: uvarint length, bytes
+//	meta:      uvarint count, then that many pairs of
+//	           keys and values (each a uvarint length
+//	           followed by bytes), sorted by key
+//
+// Version 1 files have no header. The rest of
+// the file is a sequence of records, each beginning
+// with a tag byte:
+//
+//	string: uvarint length, bytes
+//	node:   uvarint ID, uvarint cluster, attributes
+//	link:   uvarint A, uvarint B, uvarint cost, attributes
+//	end
+//
+// Strings (node IDs, cluster IDs, and attribute keys
+// and values) are stored once in a string table which
+// is built up as the file is read: each string record
+// assigns the next index, and nodes, links and attributes
+// refer to strings by index. Attributes are stored as a
+// uvarint count followed by that many pairs of key and
+// value indices.
+const (
+	BinaryMagic   = "CSGB"
+	BinaryVersion = CurrentVersion
+)
+
+const (
+	tagEnd byte = iota
+	tagString
+	tagNode
+	tagLink
+)
+
+// The maximum length of a string in the
+// binary format (to guard against allocating
+// huge buffers when reading corrupt files)
+const maxBinaryString = 1 << 20
+
+// MarshalBinary returns the binary encoding
+// of def's canonical form (see Marshal).
+func MarshalBinary(def graph.GraphDef) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(NewBinaryEncoder(&buf, Header{}), def.Canonical()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type binaryEncoder struct {
+	w       *bufio.Writer
+	strings map[string]uint64
+	tmp     [binary.MaxVarintLen64]byte
+	links   bool
+	closed  bool
+}
+
+// NewBinaryEncoder returns an Encoder which writes
+// the binary format to w, beginning with h (whose
+// Version is set to CurrentVersion).
+func NewBinaryEncoder(w io.Writer, h Header) Encoder {
+	e := &binaryEncoder{
+		w:       bufio.NewWriter(w),
+		strings: make(map[string]uint64),
+	}
+	e.w.WriteString(BinaryMagic)
+	e.uvarint(BinaryVersion)
+	e.rawString(h.Generator)
+	keys := make([]string, 0, len(h.Meta))
+	for k := range h.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e.rawString(k)
+		e.rawString(h.Meta[k])
+	}
+	return e
+}
+
+// Write s directly (rather than
+// through the string table)
+func (e *binaryEncoder) rawString(s string) {
+	e.uvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *binaryEncoder) uvarint(x uint64) {
+	n := binary.PutUvarint(e.tmp[:], x)
+	e.w.Write(e.tmp[:n])
+}
+
+// Return the index of s in the string table,
+// adding it (and writing a string record)
+// if necessary.
+func (e *binaryEncoder) str(s string) uint64 {
+	idx, ok := e.strings[s]
+	if !ok {
+		idx = uint64(len(e.strings))
+		e.strings[s] = idx
+		e.w.WriteByte(tagString)
+		e.rawString(s)
+	}
+	return idx
+}
+
+// Add the keys and values of attrs to the string
+// table, returning their indices. Strings must be
+// defined before the record which refers to them
+// begins, so this must be called before writing it.
+func (e *binaryEncoder) attrStrings(attrs map[string]string) []uint64 {
+	// Sort keys so that the encoding is deterministic
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	idxs := make([]uint64, 0, 2*len(keys))
+	for _, k := range keys {
+		idxs = append(idxs, e.str(k), e.str(attrs[k]))
+	}
+	return idxs
+}
+
+// Write attributes whose strings were
+// added by attrStrings
+func (e *binaryEncoder) attrs(idxs []uint64) {
+	e.uvarint(uint64(len(idxs) / 2))
+	for _, idx := range idxs {
+		e.uvarint(idx)
+	}
+}
+
+func (e *binaryEncoder) WriteNode(n graph.NodeDef) error {
+	switch {
+	case e.closed:
+		return fmt.Errorf("encoder closed")
+	case e.links:
+		return fmt.Errorf("cannot write node %v after links", n.ID)
+	}
+	id, c := e.str(string(n.ID)), e.str(string(n.Cluster))
+	attrs := e.attrStrings(n.Attrs)
+	e.w.WriteByte(tagNode)
+	e.uvarint(id)
+	e.uvarint(c)
+	e.attrs(attrs)
+	return nil
+}
+
+func (e *binaryEncoder) WriteLink(l graph.LinkDef) error {
+	if e.closed {
+		return fmt.Errorf("encoder closed")
+	}
+	e.links = true
+	a, b := e.str(string(l.A)), e.str(string(l.B))
+	attrs := e.attrStrings(l.Attrs)
+	e.w.WriteByte(tagLink)
+	e.uvarint(a)
+	e.uvarint(b)
+	e.uvarint(l.Cost)
+	e.attrs(attrs)
+	return nil
+}
+
+func (e *binaryEncoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	e.w.WriteByte(tagEnd)
+	return e.w.Flush()
+}
+
+// binaryDecoder reads the binary format. The
+// magic number must not yet have been consumed.
+type binaryDecoder struct {
+	r       *bufio.Reader
+	strings []string
+	done    bool
+}
+
+func (d *binaryDecoder) header() (Header, error) {
+	magic := make([]byte, len(BinaryMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil {
+		return Header{}, unexpectedEOF(err)
+	}
+	if string(magic) != BinaryMagic {
+		return Header{}, fmt.Errorf("bad magic number: %q", magic)
+	}
+	version, err := d.uvarint()
+	if err != nil {
+		return Header{}, err
+	}
+	// Check before reading the header, whose
+	// layout may differ in newer versions
+	if err := checkVersion(int(version)); err != nil || version == 1 {
+		return Header{Version: int(version)}, err
+	}
+
+	h := Header{Version: int(version)}
+	if h.Generator, err = d.rawString(); err != nil {
+		return Header{}, err
+	}
+	n, err := d.uvarint()
+	if err != nil {
+		return Header{}, err
+	}
+	for i := uint64(0); i < n; i++ {
+		k, err := d.rawString()
+		if err != nil {
+			return Header{}, err
+		}
+		v, err := d.rawString()
+		if err != nil {
+			return Header{}, err
+		}
+		if h.Meta == nil {
+			h.Meta = make(map[string]string)
+		}
+		h.Meta[k] = v
+	}
+	return h, nil
+}
+
+func (d *binaryDecoder) next() (Element, error) {
+	if d.done {
+		return Element{}, io.EOF
+	}
+	for {
+		tag, err := d.r.ReadByte()
+		if err != nil {
+			return Element{}, unexpectedEOF(err)
+		}
+		switch tag {
+		case tagEnd:
+			d.done = true
+			return Element{}, io.EOF
+		case tagString:
+			s, err := d.rawString()
+			if err != nil {
+				return Element{}, err
+			}
+			d.strings = append(d.strings, s)
+		case tagNode:
+			var n graph.NodeDef
+			id, err := d.str()
+			if err != nil {
+				return Element{}, err
+			}
+			c, err := d.str()
+			if err != nil {
+				return Element{}, err
+			}
+			n.ID, n.Cluster = graph.NodeID(id), graph.ClusterID(c)
+			if n.Attrs, err = d.attrs(); err != nil {
+				return Element{}, err
+			}
+			return Element{Node: &n}, nil
+		case tagLink:
+			var l graph.LinkDef
+			a, err := d.str()
+			if err != nil {
+				return Element{}, err
+			}
+			b, err := d.str()
+			if err != nil {
+				return Element{}, err
+			}
+			l.A, l.B = graph.NodeID(a), graph.NodeID(b)
+			if l.Cost, err = d.uvarint(); err != nil {
+				return Element{}, err
+			}
+			if l.Attrs, err = d.attrs(); err != nil {
+				return Element{}, err
+			}
+			return Element{Link: &l}, nil
+		default:
+			return Element{}, fmt.Errorf("bad record tag: %v", tag)
+		}
+	}
+}
+
+func (d *binaryDecoder) uvarint() (uint64, error) {
+	x, err := binary.ReadUvarint(d.r)
+	return x, unexpectedEOF(err)
+}
+
+func (d *binaryDecoder) rawString() (string, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > maxBinaryString {
+		return "", fmt.Errorf("string too long: %v bytes", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return "", unexpectedEOF(err)
+	}
+	return string(buf), nil
+}
+
+func (d *binaryDecoder) str() (string, error) {
+	idx, err := d.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if idx >= uint64(len(d.strings)) {
+		return "", fmt.Errorf("bad string index: %v", idx)
+	}
+	return d.strings[idx], nil
+}
+
+func (d *binaryDecoder) attrs() (map[string]string, error) {
+	n, err := d.uvarint()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	attrs := make(map[string]string)
+	for i := uint64(0); i < n; i++ {
+		k, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.str()
+		if err != nil {
+			return nil, err
+		}
+		attrs[k] = v
+	}
+	return attrs, nil
+}
+
+// Inside a file, running out of data is an error
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
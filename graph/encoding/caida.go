@@ -0,0 +1,267 @@
+package encoding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// CAIDA AS relationship files (https://www.caida.org/catalog/datasets/as-relationships/)
+// list one relationship per line:
+//
+//	serial-1: as1|as2|rel
+//	serial-2: as1|as2|rel|source
+//
+// where rel is -1 if as1 is a provider of as2, 0 if
+// they are peers, and 1 if they are siblings (used by
+// some older files). Lines beginning with '#' are
+// comments. Each relationship becomes a link from as1
+// to as2 with cost 1 whose "relationship" attribute is
+// "p2c" (A is a provider of B), "p2p" (peers) or "s2s"
+// (siblings). In serial-2 files,
+// the source of the inference is recorded in the
+// "source" attribute.
+//
+// By default, each AS is placed in a cluster of its
+// own. With the "clusterBy" option, a starting partition
+// can be chosen instead:
+//
+//	org:  ASes belonging to the same organization (as
+//	      given by the as2org file named by the "as2org"
+//	      option) share a cluster.
+//	cone: each AS joins the cluster of the top-level
+//	      provider (an AS with no providers) whose
+//	      customer cone it is in, found by repeatedly
+//	      following the AS's lowest-numbered provider.
+
+// A relationship from the perspective
+// of the first AS of a line
+const (
+	caidaP2C = -1
+	caidaP2P = 0
+	caidaS2S = 1
+)
+
+// DecodeCAIDA reads a CAIDA AS relationship file from r.
+func DecodeCAIDA(r io.Reader, opts Options) (graph.GraphDef, error) {
+	var def graph.GraphDef
+
+	clusterBy := opts.get("clusterBy", "")
+	switch clusterBy {
+	case "", "org", "cone":
+	default:
+		return def, fmt.Errorf("unknown clustering: %v", clusterBy)
+	}
+
+	type rel struct {
+		a, b   graph.NodeID
+		rel    int
+		source string
+	}
+	var rels []rel
+	ases := make(map[graph.NodeID]bool)
+	// The providers of each AS
+	providers := make(map[graph.NodeID][]graph.NodeID)
+	seen := make(map[[2]graph.NodeID]bool)
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "|")
+		if len(fields) != 3 && len(fields) != 4 {
+			return def, fmt.Errorf("line %v: expected 3 or 4 fields; got %v", line, len(fields))
+		}
+		for _, f := range fields[:2] {
+			if _, err := strconv.ParseUint(f, 10, 32); err != nil {
+				return def, fmt.Errorf("line %v: bad AS number: %q", line, f)
+			}
+		}
+		r := rel{a: graph.NodeID(fields[0]), b: graph.NodeID(fields[1])}
+		switch fields[2] {
+		case "-1":
+			r.rel = caidaP2C
+		case "0":
+			r.rel = caidaP2P
+		case "1":
+			r.rel = caidaS2S
+		default:
+			return def, fmt.Errorf("line %v: bad relationship: %q", line, fields[2])
+		}
+		if len(fields) == 4 {
+			r.source = fields[3]
+		}
+		if r.a == r.b {
+			return def, fmt.Errorf("line %v: relationship of AS %v with itself", line, r.a)
+		}
+		key := [2]graph.NodeID{r.a, r.b}
+		if key[1] < key[0] {
+			key[0], key[1] = key[1], key[0]
+		}
+		if seen[key] {
+			return def, fmt.Errorf("line %v: duplicate relationship between ASes %v and %v", line, r.a, r.b)
+		}
+		seen[key] = true
+
+		ases[r.a], ases[r.b] = true, true
+		if r.rel == caidaP2C {
+			providers[r.b] = append(providers[r.b], r.a)
+		}
+		rels = append(rels, r)
+	}
+	if err := s.Err(); err != nil {
+		return def, err
+	}
+
+	var clusters map[graph.NodeID]graph.ClusterID
+	switch clusterBy {
+	case "org":
+		orgs, err := readAS2Org(opts.get("as2org", ""))
+		if err != nil {
+			return def, err
+		}
+		clusters = make(map[graph.NodeID]graph.ClusterID)
+		for as := range ases {
+			if org, ok := orgs[as]; ok {
+				clusters[as] = graph.ClusterID(org)
+			}
+		}
+	case "cone":
+		clusters = caidaCones(ases, providers)
+	}
+
+	for as := range ases {
+		n := graph.NodeDef{ID: as, Cluster: graph.ClusterID(as)}
+		if c, ok := clusters[as]; ok {
+			n.Cluster = c
+		}
+		def.Nodes = append(def.Nodes, n)
+	}
+	for _, r := range rels {
+		var name string
+		switch r.rel {
+		case caidaP2C:
+			name = "p2c"
+		case caidaP2P:
+			name = "p2p"
+		case caidaS2S:
+			name = "s2s"
+		}
+		l := graph.LinkDef{A: r.a, B: r.b, Cost: 1, Attrs: map[string]string{"relationship": name}}
+		if r.source != "" {
+			l.Attrs["source"] = r.source
+		}
+		def.Links = append(def.Links, l)
+	}
+	return def, nil
+}
+
+// Assign each AS to the cone of a top-level provider
+func caidaCones(ases map[graph.NodeID]bool, providers map[graph.NodeID][]graph.NodeID) map[graph.NodeID]graph.ClusterID {
+	cones := make(map[graph.NodeID]graph.ClusterID)
+	var top func(as graph.NodeID, visiting map[graph.NodeID]bool) graph.ClusterID
+	top = func(as graph.NodeID, visiting map[graph.NodeID]bool) graph.ClusterID {
+		if c, ok := cones[as]; ok {
+			return c
+		}
+		ps := providers[as]
+		// Provider loops occasionally appear in the
+		// inferred data; treat an AS in one as a
+		// top-level provider
+		if len(ps) == 0 || visiting[as] {
+			return graph.ClusterID(as)
+		}
+		visiting[as] = true
+		lowest := ps[0]
+		for _, p := range ps[1:] {
+			if asLess(p, lowest) {
+				lowest = p
+			}
+		}
+		c := top(lowest, visiting)
+		cones[as] = c
+		return c
+	}
+	// Visit ASes in order so that the cone
+	// of a loop doesn't depend on which of
+	// its ASes is visited first
+	sorted := make([]graph.NodeID, 0, len(ases))
+	for as := range ases {
+		sorted = append(sorted, as)
+	}
+	sort.Sort(asList(sorted))
+	for _, as := range sorted {
+		top(as, make(map[graph.NodeID]bool))
+	}
+	return cones
+}
+
+type asList []graph.NodeID
+
+func (a asList) Len() int           { return len(a) }
+func (a asList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a asList) Less(i, j int) bool { return asLess(a[i], a[j]) }
+
+// Compare AS numbers numerically
+func asLess(a, b graph.NodeID) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// Read a CAIDA as2org file, returning the organization
+// ID of each AS. The file consists of two sections,
+// each introduced by a comment describing its format:
+//
+//	# format:org_id|changed|org_name|country|source
+//	# format:aut|changed|aut_name|org_id|opaque_id|source
+func readAS2Org(filename string) (map[graph.NodeID]string, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("no as2org file specified")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	orgs := make(map[graph.NodeID]string)
+	// The index of the aut and org_id
+	// fields in the current section
+	// (or -1 if not in an AS section)
+	aut, org := -1, -1
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# format:") {
+			aut, org = -1, -1
+			for j, f := range strings.Split(strings.TrimPrefix(line, "# format:"), "|") {
+				switch f {
+				case "aut":
+					aut = j
+				case "org_id":
+					org = j
+				}
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") || aut < 0 || org < 0 {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) <= aut || len(fields) <= org {
+			return nil, fmt.Errorf("%v: line %v: too few fields", filename, i+1)
+		}
+		orgs[graph.NodeID(fields[aut])] = fields[org]
+	}
+	if len(orgs) == 0 {
+		return nil, fmt.Errorf("%v: no AS to organization mappings found", filename)
+	}
+	return orgs, nil
+}
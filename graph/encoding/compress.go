@@ -0,0 +1,104 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// The length of a bzip2 header: "BZh", the block
+// size ('1' to '9'), and the 6-byte magic number
+// of the first block (or of the end of the stream,
+// if it's empty)
+const bzip2HeaderLen = 10
+
+var (
+	bzip2Magic      = []byte("BZh")
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+)
+
+// Report whether prefix begins with a bzip2 header.
+// "BZh" alone is too short to rule out text (such
+// as an edge list whose first node ID begins "BZh").
+func isBzip2(prefix []byte) bool {
+	if len(prefix) < bzip2HeaderLen || !bytes.HasPrefix(prefix, bzip2Magic) {
+		return false
+	}
+	if size := prefix[3]; size < '1' || size > '9' {
+		return false
+	}
+	magic := prefix[4:bzip2HeaderLen]
+	return bytes.Equal(magic, bzip2BlockMagic) || bytes.Equal(magic, bzip2EndMagic)
+}
+
+// Compressions lists the names of the compression
+// formats supported by NewCompressor.
+var Compressions = []string{"none", "gzip"}
+
+// Decompress returns a Reader which reads the
+// decompressed contents of r if r is compressed
+// with gzip or bzip2 (as detected by its magic
+// number), or the contents of r otherwise.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	// If this fails, there isn't enough data
+	// to be compressed; let the caller deal
+	// with whatever there is.
+	magic, _ := br.Peek(bzip2HeaderLen)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case isBzip2(magic):
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// NewCompressor returns a WriteCloser which compresses
+// data written to it in the named format (one of
+// Compressions) and writes it to w. Closing it
+// flushes any buffered data, but does not close w.
+func NewCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "none":
+		return nopCloser{w}, nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown compression: %v", compression)
+}
+
+// CompressionExt returns the extension conventionally
+// given to files compressed in the named format
+// (such as ".gz"), or "" for no compression.
+func CompressionExt(compression string) string {
+	switch compression {
+	case "gzip":
+		return ".gz"
+	}
+	return ""
+}
+
+// TrimCompressionExt removes the extension
+// of a compressed file, if any, from name.
+func TrimCompressionExt(name string) string {
+	for _, ext := range []string{".gz", ".bz2"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,173 @@
+package encoding
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// A Conversion describes how Convert converts
+// a graph definition.
+type Conversion struct {
+	// Format is the format of the input. If it is
+	// empty, the format is detected (see Detect),
+	// and DefaultFormat is used if it can't be.
+	Format        string
+	DefaultFormat string
+	// Options are passed to the input
+	// format's decoder.
+	Options Options
+	// OutputFormat is the format to write
+	// (by default, "json"), and Compression
+	// the compression to apply to it (by
+	// default, "none").
+	OutputFormat string
+	Compression  string
+	// Header begins the output. The input format
+	// is recorded in its "input" metadata.
+	Header Header
+	// Stream converts edge lists with bounded
+	// memory (see StreamEdgeList). It is an error
+	// if the input is in any other format, if the
+	// output format is Buffered, or if any
+	// preprocessing is requested.
+	Stream bool
+	// Preprocess is applied to the definition
+	// before it is written (see graph.Preprocess).
+	Preprocess graph.Preprocessing
+}
+
+// A ConvertError is returned by Convert, recording
+// whether reading ("read"), parsing ("parse") or
+// writing ("write") the definition failed.
+type ConvertError struct {
+	Op  string
+	Err error
+}
+
+func (e *ConvertError) Error() string {
+	switch e.Op {
+	case "read":
+		return fmt.Sprintf("error reading input: %v", e.Err)
+	case "write":
+		return fmt.Sprintf("error writing output: %v", e.Err)
+	}
+	return fmt.Sprintf("error parsing input: %v", e.Err)
+}
+
+// ConvertStats describes a converted definition.
+type ConvertStats struct {
+	// Format is the format the input was read in.
+	Format       string
+	Nodes, Links int
+	// Report describes what preprocessing removed.
+	Report graph.PreprocessReport
+}
+
+// Convert reads a graph definition from r and writes it to
+// w as described by c. name is the name of the input file
+// (or "" if unknown), which is used to detect its format.
+// Unless streaming, the definition is written in its
+// canonical form.
+func Convert(w io.Writer, r io.Reader, name string, c Conversion) (ConvertStats, error) {
+	var stats ConvertStats
+	if c.OutputFormat == "" {
+		c.OutputFormat = "json"
+	}
+	if c.Compression == "" {
+		c.Compression = "none"
+	}
+	cw, err := NewCompressor(w, c.Compression)
+	if err != nil {
+		return stats, err
+	}
+
+	format, r, err := Detect(r, name)
+	if err != nil {
+		return stats, &ConvertError{"read", err}
+	}
+	switch {
+	case c.Format != "":
+		format = c.Format
+	case format == "":
+		format = c.DefaultFormat
+	}
+	stats.Format = format
+	if format == "" {
+		return stats, &ConvertError{"parse", fmt.Errorf("unable to detect format")}
+	}
+	if c.Stream && format != "edge-list" {
+		return stats, fmt.Errorf("streaming conversion is only supported for edge lists")
+	}
+	if c.Stream && preprocessing(c.Preprocess) {
+		return stats, fmt.Errorf("streaming conversion does not support preprocessing")
+	}
+	if f, ok := Lookup(c.OutputFormat); c.Stream && ok && f.Buffered {
+		return stats, fmt.Errorf("streaming conversion does not support the %v output format, which is written all at once", c.OutputFormat)
+	}
+
+	h := c.Header
+	meta := map[string]string{"input": format}
+	for k, v := range h.Meta {
+		meta[k] = v
+	}
+	h.Meta = meta
+	e, err := NewFormatEncoder(c.OutputFormat, cw, h)
+	if err != nil {
+		return stats, err
+	}
+	enc := &countingEncoder{Encoder: e, stats: &stats}
+
+	if c.Stream {
+		// Errors can come from either side,
+		// so don't try to tell them apart
+		if err := StreamEdgeList(r, enc, c.Options); err != nil {
+			return stats, &ConvertError{"parse", err}
+		}
+	} else {
+		d, err := NewFormatDecoder(format, r, name, c.Options)
+		if err != nil {
+			return stats, &ConvertError{"parse", err}
+		}
+		def, err := Decode(d)
+		if err != nil {
+			return stats, &ConvertError{"parse", err}
+		}
+		if preprocessing(c.Preprocess) {
+			def, stats.Report, err = graph.Preprocess(def, c.Preprocess)
+			if err != nil {
+				return stats, &ConvertError{"parse", err}
+			}
+		}
+		if err := Encode(enc, def.Canonical()); err != nil {
+			return stats, &ConvertError{"write", err}
+		}
+	}
+	if err := cw.Close(); err != nil {
+		return stats, &ConvertError{"write", err}
+	}
+	return stats, nil
+}
+
+func preprocessing(p graph.Preprocessing) bool {
+	return p.Rename != nil || p.DropSelfLinks || p.DropDuplicates ||
+		p.LargestComponent || p.MinComponentSize > 0 || p.Relabel
+}
+
+// A countingEncoder counts the nodes
+// and links written to an Encoder
+type countingEncoder struct {
+	Encoder
+	stats *ConvertStats
+}
+
+func (c *countingEncoder) WriteNode(n graph.NodeDef) error {
+	c.stats.Nodes++
+	return c.Encoder.WriteNode(n)
+}
+
+func (c *countingEncoder) WriteLink(l graph.LinkDef) error {
+	c.stats.Links++
+	return c.Encoder.WriteLink(l)
+}
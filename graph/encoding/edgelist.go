@@ -0,0 +1,158 @@
+package encoding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// Edge lists have one link per line, given as two node
+// IDs and an optional cost (which defaults to 1),
+// separated by any amount of whitespace:
+//
+//	a b [cost]
+//
+// A line with a single node ID defines an isolated node.
+// Blank lines and lines beginning with '#' or '%' are
+// ignored. A link may be listed in either or both
+// orientations (but with the same cost). Self-links
+// are omitted (though their node is still defined).
+//
+// Every node is placed in a cluster of its own unless
+// a cluster file is named by the "clusters" option.
+// Each of its lines (with the same comment syntax)
+// gives a node ID and the cluster to place it in:
+//
+//	node cluster
+
+// DecodeEdgeList reads an edge list from r. Nodes
+// and links are returned in the order in which they
+// first appear.
+func DecodeEdgeList(r io.Reader, opts Options) (graph.GraphDef, error) {
+	var def graph.GraphDef
+
+	clusters, err := readClusterFile(opts.get("clusters", ""))
+	if err != nil {
+		return def, err
+	}
+
+	nodes := make(map[graph.NodeID]bool)
+	addNode := func(n graph.NodeID) {
+		if nodes[n] {
+			return
+		}
+		nodes[n] = true
+		c, ok := clusters[n]
+		if !ok {
+			c = graph.ClusterID(n)
+		}
+		def.Nodes = append(def.Nodes, graph.NodeDef{ID: n, Cluster: c})
+	}
+	// The index of each link in def.Links,
+	// keyed by its endpoints in order
+	links := make(map[[2]graph.NodeID]int)
+	var selfLinks int
+
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || isComment(fields[0]) {
+			continue
+		}
+		l, err := parseEdgeListLine(fields, line)
+		if err != nil {
+			return def, err
+		}
+		if l.B == "" {
+			addNode(l.A)
+			continue
+		}
+		addNode(l.A)
+		addNode(l.B)
+		if l.A == l.B {
+			selfLinks++
+			continue
+		}
+
+		key := [2]graph.NodeID{l.A, l.B}
+		if key[1] < key[0] {
+			key[0], key[1] = key[1], key[0]
+		}
+		if i, ok := links[key]; ok {
+			if def.Links[i].Cost != l.Cost {
+				return def, fmt.Errorf("line %v: link %v-%v has cost %v, but was previously given cost %v",
+					line, l.A, l.B, l.Cost, def.Links[i].Cost)
+			}
+			continue
+		}
+		links[key] = len(def.Links)
+		def.Links = append(def.Links, l)
+	}
+	if err := s.Err(); err != nil {
+		return def, err
+	}
+	if selfLinks > 0 {
+		warn("Omitting %v illegal self-links", selfLinks)
+	}
+	return def, nil
+}
+
+// Parse the (non-empty, non-comment) fields of a line
+// of an edge list. For isolated nodes, the returned
+// link's B is empty.
+func parseEdgeListLine(fields []string, line int) (graph.LinkDef, error) {
+	l := graph.LinkDef{A: graph.NodeID(fields[0]), Cost: 1}
+	switch len(fields) {
+	case 1:
+		return l, nil
+	case 2, 3:
+	default:
+		return l, fmt.Errorf("line %v: expected 1 to 3 fields; got %v: %q", line, len(fields), strings.Join(fields, " "))
+	}
+	l.B = graph.NodeID(fields[1])
+	if len(fields) == 3 {
+		var err error
+		l.Cost, err = strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return l, fmt.Errorf("line %v: bad cost: %q", line, fields[2])
+		}
+	}
+	return l, nil
+}
+
+func isComment(field string) bool {
+	return strings.HasPrefix(field, "#") || strings.HasPrefix(field, "%")
+}
+
+// Read a cluster file, returning the
+// cluster of each node it lists.
+func readClusterFile(filename string) (map[graph.NodeID]graph.ClusterID, error) {
+	clusters := make(map[graph.NodeID]graph.ClusterID)
+	if filename == "" {
+		return clusters, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || isComment(fields[0]) {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v: line %v: expected 2 fields; got %v", filename, i+1, len(fields))
+		}
+		n := graph.NodeID(fields[0])
+		if c, ok := clusters[n]; ok && c != graph.ClusterID(fields[1]) {
+			return nil, fmt.Errorf("%v: line %v: node %v is already in cluster %v", filename, i+1, n, c)
+		}
+		clusters[n] = graph.ClusterID(fields[1])
+	}
+	return clusters, nil
+}
@@ -1,20 +1,48 @@
 package encoding
 
 import (
-	"encoding/json"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 
 	"github.com/synful/cluster-simulate/graph"
 )
 
+// Unmarshal parses a graph definition in any
+// format recognized by NewDecoder.
 func Unmarshal(data []byte) (graph.GraphDef, error) {
-	def := graph.GraphDef{}
-	err := json.Unmarshal(data, &def)
-	if err != nil {
-		return graph.GraphDef{}, err
-	}
-	return def, nil
+	return Decode(NewDecoder(bytes.NewReader(data)))
 }
 
+// Marshal returns the JSON encoding of def
+// (with an empty header). The encoding is of
+// def's canonical form, so that identical graphs
+// have identical encodings.
 func Marshal(def graph.GraphDef) ([]byte, error) {
-	return json.Marshal(def)
+	var buf bytes.Buffer
+	if err := Encode(NewEncoder(&buf, Header{}), def.Canonical()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Fingerprint returns a hash (as a hexadecimal string) of
+// def's canonical form, including clusters, costs and
+// attributes, but not any header. Identical graphs have
+// identical fingerprints, regardless of the format or
+// order in which they were stored.
+func Fingerprint(def graph.GraphDef) string {
+	h := sha256.New()
+	// The binary encoding is unambiguous, and
+	// writing to a hash can't fail
+	Encode(NewBinaryEncoder(h, Header{}), def.Canonical())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// GraphFingerprint returns the Fingerprint of
+// g's definition without building a GraphDef.
+func GraphFingerprint(g *graph.Graph) string {
+	h := sha256.New()
+	WriteGraph(NewBinaryEncoder(h, Header{}), g)
+	return hex.EncodeToString(h.Sum(nil))
 }
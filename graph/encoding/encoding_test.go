@@ -1,7 +1,14 @@
 package encoding
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	. "github.com/synful/cluster-simulate/graph"
@@ -19,21 +26,21 @@ func makeTestGraph() *Graph {
 	*/
 	def := GraphDef{
 		Nodes: []NodeDef{
-			NodeDef{"A", "C1"},
-			NodeDef{"B", "C1"},
-			NodeDef{"C", "C1"},
-			NodeDef{"D", "C2"},
-			NodeDef{"E", "C2"},
-			NodeDef{"F", "C3"},
+			NodeDef{ID: "A", Cluster: "C1"},
+			NodeDef{ID: "B", Cluster: "C1"},
+			NodeDef{ID: "C", Cluster: "C1"},
+			NodeDef{ID: "D", Cluster: "C2"},
+			NodeDef{ID: "E", Cluster: "C2"},
+			NodeDef{ID: "F", Cluster: "C3"},
 		},
 		Links: []LinkDef{
-			LinkDef{"A", "B", 1},
-			LinkDef{"B", "C", 2},
-			LinkDef{"C", "A", 3},
-			LinkDef{"C", "D", 4},
-			LinkDef{"D", "E", 5},
-			LinkDef{"E", "F", 6},
-			LinkDef{"F", "D", 7},
+			LinkDef{A: "A", B: "B", Cost: 1},
+			LinkDef{A: "B", B: "C", Cost: 2},
+			LinkDef{A: "C", B: "A", Cost: 3},
+			LinkDef{A: "C", B: "D", Cost: 4},
+			LinkDef{A: "D", B: "E", Cost: 5},
+			LinkDef{A: "E", B: "F", Cost: 6},
+			LinkDef{A: "F", B: "D", Cost: 7},
 		},
 	}
 
@@ -52,21 +59,21 @@ func makeTestGraphNoClusters() *Graph {
 	*/
 	def := GraphDef{
 		Nodes: []NodeDef{
-			NodeDef{"A", "C1"},
-			NodeDef{"B", "C2"},
-			NodeDef{"C", "C3"},
-			NodeDef{"D", "C4"},
-			NodeDef{"E", "C5"},
-			NodeDef{"F", "C6"},
+			NodeDef{ID: "A", Cluster: "C1"},
+			NodeDef{ID: "B", Cluster: "C2"},
+			NodeDef{ID: "C", Cluster: "C3"},
+			NodeDef{ID: "D", Cluster: "C4"},
+			NodeDef{ID: "E", Cluster: "C5"},
+			NodeDef{ID: "F", Cluster: "C6"},
 		},
 		Links: []LinkDef{
-			LinkDef{"A", "B", 1},
-			LinkDef{"B", "C", 2},
-			LinkDef{"C", "A", 3},
-			LinkDef{"C", "D", 4},
-			LinkDef{"D", "E", 5},
-			LinkDef{"E", "F", 6},
-			LinkDef{"F", "D", 7},
+			LinkDef{A: "A", B: "B", Cost: 1},
+			LinkDef{A: "B", B: "C", Cost: 2},
+			LinkDef{A: "C", B: "A", Cost: 3},
+			LinkDef{A: "C", B: "D", Cost: 4},
+			LinkDef{A: "D", B: "E", Cost: 5},
+			LinkDef{A: "E", B: "F", Cost: 6},
+			LinkDef{A: "F", B: "D", Cost: 7},
 		},
 	}
 
@@ -90,8 +97,855 @@ func testEncoding(g *Graph, t *testing.T) {
 	}
 	if !g.Equal(h) {
 		fmt.Println(g)
-		fmt.Println("\n")
+		fmt.Println()
 		fmt.Println(h)
 		t.Errorf("Expected graphs to be equal")
 	}
 }
+
+func TestStreaming(t *testing.T) {
+	testStreaming(makeTestGraph(), t)
+	testStreaming(makeTestGraphNoClusters(), t)
+}
+
+func testStreaming(g *Graph, t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteGraph(NewEncoder(&buf, Header{}), g); err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+
+	// The streaming encoding must be
+	// readable by Unmarshal...
+	def, err := Unmarshal(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Error unmarshalling: %v", err)
+	}
+	if h := NewGraph(def, MaxCost); !g.Equal(h) {
+		t.Errorf("Expected graphs to be equal; were not: \ng:\n%vh:\n%v", g, h)
+	}
+
+	// ...and vice versa
+	data, err := Marshal(g.GraphDef())
+	if err != nil {
+		t.Fatalf("Error marshalling: %v", err)
+	}
+	h, err := ReadGraph(NewDecoder(bytes.NewReader(data)), MaxCost)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if !g.Equal(h) {
+		t.Errorf("Expected graphs to be equal; were not: \ng:\n%vh:\n%v", g, h)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	// Links before nodes, unknown keys, and null
+	src := `{"Links":[{"A":"A","B":"B","Cost":3,"Attrs":{"latency":"2ms"}}],"Foo":{"Bar":[1]},` +
+		`"Nodes":[{"ID":"A","Cluster":"C1"},{"ID":"B","Cluster":"C1","Attrs":{"x":"1"}}],"Other":null}`
+	def, err := Decode(NewDecoder(strings.NewReader(src)))
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if len(def.Nodes) != 2 || len(def.Links) != 1 {
+		t.Fatalf("Expected 2 nodes and 1 link; got %+v", def)
+	}
+	if def.Links[0].Attrs["latency"] != "2ms" || def.Nodes[1].Attrs["x"] != "1" {
+		t.Errorf("Expected attributes to be decoded; got %+v", def)
+	}
+	if _, err := ReadGraph(NewDecoder(strings.NewReader(src)), MaxCost); err != nil {
+		t.Errorf("Error reading graph: %v", err)
+	}
+
+	bad := []string{"", "[]", `{"Nodes":[`, `{"Nodes":{}}`, `{"Links":[{"A":"A","B":"B"}]}`}
+	for _, src := range bad {
+		if _, err := ReadGraph(NewDecoder(strings.NewReader(src)), MaxCost); err == nil {
+			t.Errorf("Expected error decoding %q", src)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf, Header{})
+	if err := e.Close(); err != nil {
+		t.Fatalf("Error closing encoder: %v", err)
+	}
+	if s := buf.String(); s != `{"Version":2,"Nodes":[],"Links":[]}` {
+		t.Errorf("Unexpected encoding of empty graph: %v", s)
+	}
+
+	e = NewEncoder(&buf, Header{})
+	e.WriteLink(LinkDef{A: "A", B: "B"})
+	if err := e.WriteNode(NodeDef{ID: "A"}); err == nil {
+		t.Errorf("Expected error writing node after link")
+	}
+}
+
+func TestBinary(t *testing.T) {
+	for _, g := range []*Graph{makeTestGraph(), makeTestGraphNoClusters()} {
+		def := g.GraphDef()
+		def.Nodes[0].Attrs = map[string]string{"lat": "1.5", "long": "-3"}
+		def.Links[0].Attrs = map[string]string{"lat": "1.5"}
+		g = NewGraph(def, MaxCost)
+
+		data, err := MarshalBinary(def)
+		if err != nil {
+			t.Fatalf("Error marshalling: %v", err)
+		}
+		jsonData, _ := Marshal(def)
+		if len(data) >= len(jsonData) {
+			t.Errorf("Expected binary encoding (%v bytes) to be smaller than JSON (%v bytes)", len(data), len(jsonData))
+		}
+
+		def2, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("Error unmarshalling: %v", err)
+		}
+		if h := NewGraph(def2, MaxCost); !g.Equal(h) {
+			t.Errorf("Expected graphs to be equal; were not: \ng:\n%vh:\n%v", g, h)
+		}
+		if def2.Nodes[0].Attrs["long"] != "-3" || def2.Links[0].Attrs["lat"] != "1.5" {
+			t.Errorf("Expected attributes to survive encoding; got %+v and %+v", def2.Nodes[0], def2.Links[0])
+		}
+		// The encoding must not depend on map order
+		for i := 0; i < 10; i++ {
+			if data2, _ := MarshalBinary(def); !bytes.Equal(data, data2) {
+				t.Fatalf("Expected the same encoding every time")
+			}
+		}
+
+		// Truncated files must be rejected
+		for i := 0; i < len(data); i++ {
+			if _, err := Unmarshal(data[:i]); err == nil {
+				t.Errorf("Expected error unmarshalling %v of %v bytes", i, len(data))
+			}
+		}
+	}
+
+	if _, err := Unmarshal([]byte(BinaryMagic + "\x09")); err == nil {
+		t.Errorf("Expected error for unknown version")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	h := Header{Generator: "test", Meta: map[string]string{"round": "3"}}
+	def := makeTestGraph().GraphDef()
+	for _, format := range WritableFormats() {
+		var buf bytes.Buffer
+		e, _ := NewFormatEncoder(format, &buf, h)
+		if err := Encode(e, def); err != nil {
+			t.Fatalf("%v: error encoding: %v", format, err)
+		}
+		h2, err := NewDecoder(&buf).Header()
+		if err != nil {
+			t.Fatalf("%v: error reading header: %v", format, err)
+		}
+		if h2.Version != CurrentVersion || h2.Generator != "test" || h2.Meta["round"] != "3" {
+			t.Errorf("%v: unexpected header: %+v", format, h2)
+		}
+	}
+
+	// Version 1 (headerless) definitions
+	// must still be readable
+	old := []string{
+		`{"Nodes":[{"ID":"A","Cluster":"A"}],"Links":[]}`,
+		BinaryMagic + "\x01\x01\x01A\x02\x00\x00\x00\x00",
+	}
+	for _, src := range old {
+		d := NewDecoder(strings.NewReader(src))
+		if h, err := d.Header(); err != nil || h.Version != 1 {
+			t.Errorf("Unexpected header for %q: %+v (error: %v)", src, h, err)
+		}
+		def, err := Decode(d)
+		if err != nil || len(def.Nodes) != 1 {
+			t.Errorf("Unexpected result decoding %q: %+v (error: %v)", src, def, err)
+		}
+	}
+
+	bad := []string{
+		`{"Version":3,"Nodes":[],"Links":[]}`,
+		`{"Version":-1,"Nodes":[],"Links":[]}`,
+		`{"Nodes":[],"Version":2,"Links":[]}`,
+		BinaryMagic + "\x03",
+	}
+	for _, src := range bad {
+		if _, err := Decode(NewDecoder(strings.NewReader(src))); err == nil {
+			t.Errorf("Expected error decoding %q", src)
+		}
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	g := makeTestGraph()
+	def := g.GraphDef()
+	def.Nodes[0].Attrs = map[string]string{"label": "a <b> & c"}
+	def.Links[0].Attrs = map[string]string{"LinkSpeed": "10"}
+	g = NewGraph(def, MaxCost)
+
+	var buf bytes.Buffer
+	h := Header{Generator: "test", Meta: map[string]string{"round": "3"}}
+	if err := Encode(NewGraphMLEncoder(&buf, h), def); err != nil {
+		t.Fatalf("Error encoding: %v", err)
+	}
+	d := NewDecoder(&buf)
+	h2, err := d.Header()
+	if err != nil {
+		t.Fatalf("Error reading header: %v", err)
+	}
+	if h2.Generator != "test" || h2.Meta["round"] != "3" {
+		t.Errorf("Unexpected header: %+v", h2)
+	}
+	def2, err := Decode(d)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if h := NewGraph(def2, MaxCost); !g.Equal(h) {
+		t.Errorf("Expected graphs to be equal; were not: \ng:\n%vh:\n%v", g, h)
+	}
+	if def2.Nodes[0].Attrs["label"] != "a <b> & c" {
+		t.Errorf("Expected attributes to survive encoding; got %+v", def2.Nodes[0])
+	}
+
+	src := `<?xml version="1.0"?>
+<graphml>
+  <key id="k0" for="node" attr.name="cluster"/>
+  <key id="k1" for="edge" attr.name="weight"/>
+  <key id="k2" for="edge" attr.name="cost"><default>2</default></key>
+  <graph edgedefault="directed">
+    <node id="A"><data key="k0">X</data></node>
+    <node id="B"><data key="k0">X</data></node>
+    <edge source="A" target="B"><data key="k1">7</data></edge>
+    <edge source="B" target="A"/>
+    <edge source="A" target="A"/>
+    <node id="C"/>
+    <edge source="B" target="C"><data key="k2">3.6</data></edge>
+  </graph>
+</graphml>`
+	def, err = Decode(NewGraphMLDecoder(strings.NewReader(src)))
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if len(def.Nodes) != 3 || len(def.Links) != 2 {
+		t.Fatalf("Expected 3 nodes and 2 links; got %+v", def)
+	}
+	if def.Nodes[0].Cluster != "X" || def.Nodes[2].Cluster != "C" {
+		t.Errorf("Unexpected clusters: %+v", def.Nodes)
+	}
+	if l := def.Links[0]; l.Cost != 2 || l.Attrs["weight"] != "7" {
+		t.Errorf("Unexpected link: %+v", l)
+	}
+	if l := def.Links[1]; l.Cost != 4 {
+		t.Errorf("Unexpected link: %+v", l)
+	}
+
+	bad := []string{
+		`<graphml></graphml>`,
+		`<graphml><graph><node/></graph></graphml>`,
+		`<graphml><graph><node id="A"/><edge source="A" target="B"><data key="cost">x</data></edge></graph></graphml>`,
+		`<graphml><graph><node id="A"/>`,
+	}
+	for _, src := range bad {
+		if _, err := Decode(NewGraphMLDecoder(strings.NewReader(src))); err == nil {
+			t.Errorf("Expected error decoding %q", src)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	def := makeTestGraph().GraphDef()
+	data, _ := Marshal(def)
+	for _, c := range Compressions {
+		var buf bytes.Buffer
+		w, err := NewCompressor(&buf, c)
+		if err != nil {
+			t.Fatalf("Error creating %v compressor: %v", c, err)
+		}
+		w.Write(data)
+		w.Close()
+		if c != "none" && bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("%v: expected data to be compressed", c)
+		}
+		def2, err := Decode(NewDecoder(&buf))
+		if err != nil {
+			t.Fatalf("%v: error decoding: %v", c, err)
+		}
+		if !makeTestGraph().Equal(NewGraph(def2, MaxCost)) {
+			t.Errorf("%v: expected graphs to be equal", c)
+		}
+	}
+
+	if _, err := Decode(NewDecoder(bytes.NewReader(gzipMagic))); err == nil {
+		t.Errorf("Expected error decoding truncated gzip data")
+	}
+
+	// bzip2 is recognized by its whole header, not
+	// just "BZh", which text can begin with too
+	bz := "BZh91AY&SY\x84aA.\x00\x00\x03\\\x00\x00\x10@\x00\x08\x008\x00 \x00\"\x1e\x93j\x0c\x02U\x8a\x07\x8b\xb9\"\x9c(HB0\xa0\x97\x00"
+	for _, src := range []string{bz, "BZh1 BZh2\nBZh2 C 3\n"} {
+		r, err := Decompress(strings.NewReader(src))
+		if err != nil {
+			t.Errorf("%q: error decompressing: %v", src, err)
+			continue
+		}
+		def, err := DecodeEdgeList(r, nil)
+		if err != nil || len(def.Nodes) != 3 || len(def.Links) != 2 {
+			t.Errorf("%q: unexpected edge list: %+v (error: %v)", src, def, err)
+		}
+	}
+	if n := TrimCompressionExt("0001.def.gz"); n != "0001.def" {
+		t.Errorf("Unexpected name with extension trimmed: %v", n)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	def := makeTestGraph().GraphDef()
+	// Reverse nodes and links, flip link
+	// directions, and add a duplicate link
+	var def2 GraphDef
+	for i := len(def.Nodes) - 1; i >= 0; i-- {
+		def2.Nodes = append(def2.Nodes, def.Nodes[i])
+	}
+	for i := len(def.Links) - 1; i >= 0; i-- {
+		l := def.Links[i]
+		l.A, l.B = l.B, l.A
+		def2.Links = append(def2.Links, l)
+	}
+	def2.Links = append(def2.Links, def.Links[0])
+
+	data, _ := Marshal(def)
+	data2, _ := Marshal(def2)
+	if !bytes.Equal(data, data2) {
+		t.Errorf("Expected identical encodings; got:\n%s\nand:\n%s", data, data2)
+	}
+	data, _ = MarshalBinary(def)
+	data2, _ = MarshalBinary(def2)
+	if !bytes.Equal(data, data2) {
+		t.Errorf("Expected identical binary encodings")
+	}
+
+	f := Fingerprint(def)
+	if f2 := Fingerprint(def2); f != f2 {
+		t.Errorf("Expected identical fingerprints; got %v and %v", f, f2)
+	}
+	if f2 := GraphFingerprint(makeTestGraph()); f != f2 {
+		t.Errorf("Expected graph fingerprint %v; got %v", f, f2)
+	}
+	def2.Links[0].Cost++
+	if f2 := Fingerprint(def2); f == f2 {
+		t.Errorf("Expected fingerprint to change with cost")
+	}
+
+	// Fingerprints must not depend on
+	// the order of attribute maps
+	def.Nodes[0].Attrs = map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	def.Links[0].Attrs = map[string]string{"w": "1", "x": "2", "y": "3", "z": "4"}
+	f = Fingerprint(def)
+	for i := 0; i < 20; i++ {
+		if f2 := Fingerprint(def); f != f2 {
+			t.Fatalf("Expected identical fingerprints with several attributes; got %v and %v", f, f2)
+		}
+	}
+}
+
+// Snapshot the registered formats, returning a
+// function which restores them, so that tests
+// can register formats of their own
+func saveFormats() (restore func()) {
+	saved := make([]Format, len(formats))
+	copy(saved, formats)
+	return func() { formats = saved }
+}
+
+func TestRegistry(t *testing.T) {
+	defer saveFormats()()
+
+	for name, format := range map[string]string{
+		"0001.def":                 "json",
+		"topo.GraphML.gz":          "graphml",
+		"graph.bin.bz2":            "binary",
+		"snap.txt":                 "edge-list",
+		"20150801.as-rel2.txt.bz2": "caida",
+		"1239.cch":                 "rocketfuel",
+	} {
+		if f, ok := ByExtension(name); !ok || f.Name != format {
+			t.Errorf("Expected %v to have format %v; got %q", name, format, f.Name)
+		}
+	}
+	if _, ok := ByExtension("graph.dot"); ok {
+		t.Errorf("Expected no format for unknown extension")
+	}
+
+	// Content takes precedence over extension
+	data, _ := MarshalBinary(makeTestGraph().GraphDef())
+	if format, _, err := Detect(bytes.NewReader(data), "graph.json"); err != nil || format != "binary" {
+		t.Errorf("Expected binary format to be detected; got %q (error: %v)", format, err)
+	}
+
+	// A third-party format: one node per line,
+	// all in the same cluster
+	Register(Format{
+		Name:       "test-nodes",
+		Extensions: []string{".nodes"},
+		NewDecoder: defFormat(func(r io.Reader, opts Options) (GraphDef, error) {
+			var def GraphDef
+			s := bufio.NewScanner(r)
+			for s.Scan() {
+				def.Nodes = append(def.Nodes, NodeDef{ID: NodeID(s.Text()), Cluster: ClusterID(opts["cluster"])})
+			}
+			return def, s.Err()
+		}),
+	})
+	d, err := NewFormatDecoder("", strings.NewReader("A\nB\n"), "test.nodes", Options{"cluster": "X"})
+	if err != nil {
+		t.Fatalf("Error creating decoder: %v", err)
+	}
+	def, err := Decode(d)
+	if err != nil || len(def.Nodes) != 2 || def.Nodes[1].Cluster != "X" {
+		t.Errorf("Unexpected result decoding: %+v (error: %v)", def, err)
+	}
+	if _, err := NewFormatEncoder("test-nodes", ioutil.Discard, Header{}); err == nil {
+		t.Errorf("Expected error creating encoder for read-only format")
+	}
+	if _, err := NewFormatDecoder("", strings.NewReader("A\nB\n"), "", nil); err == nil {
+		t.Errorf("Expected error detecting unrecognizable format")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic registering duplicate format")
+		}
+	}()
+	Register(Format{Name: "json"})
+}
+
+func TestEdgeList(t *testing.T) {
+	src := `# comment
+A B
+B C 3
+% another comment
+C B 3
+C C
+D
+`
+	def, err := DecodeEdgeList(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatalf("Error decoding edge list: %v", err)
+	}
+	if len(def.Nodes) != 4 || len(def.Links) != 2 || def.Links[1].Cost != 3 {
+		t.Errorf("Unexpected edge list definition: %+v", def)
+	}
+
+	if _, err := DecodeEdgeList(strings.NewReader("A B 1\nB A 2\n"), nil); err == nil {
+		t.Errorf("Expected error for conflicting costs")
+	}
+	bad := []string{"A B 1 2\n", "A B x\n", "A B -1\n"}
+	for _, src := range bad {
+		if _, err := DecodeEdgeList(strings.NewReader(src), nil); err == nil {
+			t.Errorf("Expected error decoding %q", src)
+		}
+	}
+
+	// Nodes and links appear in the order in which
+	// they're first listed, and nodes which aren't
+	// in the cluster file keep their own clusters
+	f, err := ioutil.TempFile("", "clusters")
+	if err != nil {
+		t.Fatalf("Error creating cluster file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# node cluster\nA X\nB X\n\nD Y\n")
+	f.Close()
+	def2, err := DecodeEdgeList(strings.NewReader(src), Options{"clusters": f.Name()})
+	if err != nil {
+		t.Fatalf("Error decoding edge list with clusters: %v", err)
+	}
+	expected := GraphDef{
+		Nodes: []NodeDef{{ID: "A", Cluster: "X"}, {ID: "B", Cluster: "X"}, {ID: "C", Cluster: "C"}, {ID: "D", Cluster: "Y"}},
+		Links: []LinkDef{{A: "A", B: "B", Cost: 1}, {A: "B", B: "C", Cost: 3}},
+	}
+	if !reflect.DeepEqual(def2, expected) {
+		t.Errorf("Expected %+v; got %+v", expected, def2)
+	}
+	f, _ = os.Create(f.Name())
+	f.WriteString("A X\nA Y\n")
+	f.Close()
+	if _, err := DecodeEdgeList(strings.NewReader(src), Options{"clusters": f.Name()}); err == nil {
+		t.Errorf("Expected error for node in two clusters")
+	}
+
+	// Streaming must produce the canonical
+	// form, however many chunks are used
+	// (including inputs which are mostly
+	// isolated nodes and self-links, which
+	// are chunked by their number of nodes)
+	nodesOnly := "E\nD\nC C\nE\nB\nA A\nA\n"
+	for _, src := range []string{src, nodesOnly} {
+		def, _ := DecodeEdgeList(strings.NewReader(src), nil)
+		data, _ := Marshal(def)
+		for _, chunk := range []string{"1", "2", "100"} {
+			var buf bytes.Buffer
+			err := StreamEdgeList(strings.NewReader(src), NewEncoder(&buf, Header{}), Options{"chunkSize": chunk})
+			if err != nil {
+				t.Fatalf("Error streaming edge list: %v", err)
+			}
+			if buf.String() != string(data) {
+				t.Errorf("chunk size %v: expected:\n%s\ngot:\n%s", chunk, data, buf.Bytes())
+			}
+		}
+	}
+	if err := StreamEdgeList(strings.NewReader(src), NewEncoder(ioutil.Discard, Header{}), Options{"chunkSize": "0"}); err == nil {
+		t.Errorf("Expected error for chunk size 0")
+	}
+	conflict := "A B 1\nC D\nB A 2\n"
+	if err := StreamEdgeList(strings.NewReader(conflict), NewEncoder(ioutil.Discard, Header{}), Options{"chunkSize": "1"}); err == nil {
+		t.Errorf("Expected error for costs which conflict across chunks")
+	}
+}
+
+// Return the costs of def's links, keyed by
+// their endpoints (in the order given)
+func linkCosts(def GraphDef) map[[2]NodeID]uint64 {
+	costs := make(map[[2]NodeID]uint64)
+	for _, l := range def.Links {
+		costs[[2]NodeID{l.A, l.B}] = l.Cost
+	}
+	return costs
+}
+
+func TestZoo(t *testing.T) {
+	src := `<?xml version="1.0"?>
+<graphml>
+  <key id="d0" for="node" attr.name="Latitude"/>
+  <key id="d1" for="node" attr.name="Longitude"/>
+  <key id="d2" for="edge" attr.name="LinkSpeed"/>
+  <key id="d3" for="edge" attr.name="LinkSpeedUnits"/>
+  <key id="d4" for="edge" attr.name="LinkSpeedRaw"/>
+  <key id="d5" for="edge" attr.name="LinkLabel"/>
+  <graph edgedefault="undirected">
+    <node id="A"><data key="d0">0</data><data key="d1">0</data></node>
+    <node id="B"><data key="d0">0</data><data key="d1">1</data></node>
+    <node id="C"/>
+    <edge source="A" target="B"><data key="d2">10</data><data key="d3">G</data><data key="d5">10G</data></edge>
+    <edge source="B" target="C"><data key="d4">1000000000</data></edge>
+    <edge source="A" target="C"/>
+  </graph>
+</graphml>`
+	decode := func(opts Options) (GraphDef, error) {
+		return DecodeZoo(strings.NewReader(src), opts)
+	}
+
+	def, err := decode(nil)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if n := def.Nodes[0]; n.Attrs["lat"] != "0" || n.Attrs["long"] != "0" || n.Attrs["Latitude"] != "" {
+		t.Errorf("Expected coordinates to be renamed; got %+v", n)
+	}
+	if l := def.Links[0]; l.Attrs["speed"] != "10000000000" || l.Attrs["distance"] != "111.2" || l.Attrs["label"] != "10G" {
+		t.Errorf("Unexpected link attributes: %+v", l)
+	}
+	if l := def.Links[1]; l.Attrs["speed"] != "1000000000" || l.Attrs["distance"] != "" {
+		t.Errorf("Unexpected link attributes: %+v", l)
+	}
+
+	// Links without a speed (or without both
+	// locations) have cost 1
+	for _, test := range []struct {
+		opts  Options
+		costs map[[2]NodeID]uint64
+	}{
+		{nil, map[[2]NodeID]uint64{{"A", "B"}: 10, {"B", "C"}: 100, {"A", "C"}: 1}},
+		{Options{"refBandwidth": "1e10"}, map[[2]NodeID]uint64{{"A", "B"}: 1, {"B", "C"}: 10, {"A", "C"}: 1}},
+		{Options{"refBandwidth": "1e9"}, map[[2]NodeID]uint64{{"A", "B"}: 1, {"B", "C"}: 1, {"A", "C"}: 1}},
+		{Options{"costFrom": "distance"}, map[[2]NodeID]uint64{{"A", "B"}: 111, {"B", "C"}: 1, {"A", "C"}: 1}},
+		{Options{"costFrom": "hops"}, map[[2]NodeID]uint64{{"A", "B"}: 1, {"B", "C"}: 1, {"A", "C"}: 1}},
+	} {
+		def, err := decode(test.opts)
+		if err != nil {
+			t.Errorf("%v: error decoding: %v", test.opts, err)
+			continue
+		}
+		if costs := linkCosts(def); !reflect.DeepEqual(costs, test.costs) {
+			t.Errorf("%v: expected costs %v; got %v", test.opts, test.costs, costs)
+		}
+	}
+
+	for _, opts := range []Options{{"costFrom": "latency"}, {"refBandwidth": "0"}, {"refBandwidth": "fast"}} {
+		if _, err := decode(opts); err == nil {
+			t.Errorf("%v: expected error", opts)
+		}
+	}
+}
+
+func TestRocketfuel(t *testing.T) {
+	src := `# Router 3 lists a router which isn't defined,
+# and router -4 is external to the ISP
+1 @Seattle,+WA + bb (2) &1 -> <2> <3> {-4} =r1.sea rn
+2 @Portland,+OR (1) -> <1> =r2.pdx! rn
+3 @Seattle,+WA (2) -> <1> <7> =r3.sea rn
+-4 @Elsewhere (1) -> <1> =ext rn
+`
+	def, err := DecodeRocketfuel(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if len(def.Nodes) != 3 {
+		t.Fatalf("Expected 3 nodes; got %+v", def.Nodes)
+	}
+	n := def.Nodes[0]
+	if n.Attrs["location"] != "Seattle,+WA" || n.Attrs["backbone"] != "true" || n.Attrs["name"] != "r1.sea" {
+		t.Errorf("Unexpected node attributes: %+v", n)
+	}
+	if n := def.Nodes[1]; n.Attrs["backbone"] != "" || n.Attrs["name"] != "r2.pdx" {
+		t.Errorf("Unexpected node attributes: %+v", n)
+	}
+	expected := map[[2]NodeID]uint64{{"1", "2"}: 1, {"1", "3"}: 1}
+	if costs := linkCosts(def); !reflect.DeepEqual(costs, expected) {
+		t.Errorf("Expected costs %v; got %v", expected, costs)
+	}
+
+	// Links within a location keep cost 1
+	f, err := ioutil.TempFile("", "weights")
+	if err != nil {
+		t.Fatalf("Error creating weights file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# loc1 loc2 weight\nSeattle,+WA Portland,+OR 2.6\n")
+	f.Close()
+	def, err = DecodeRocketfuel(strings.NewReader(src), Options{"weights": f.Name()})
+	if err != nil {
+		t.Fatalf("Error decoding with weights: %v", err)
+	}
+	expected[[2]NodeID{"1", "2"}] = 3
+	if costs := linkCosts(def); !reflect.DeepEqual(costs, expected) {
+		t.Errorf("Expected costs %v; got %v", expected, costs)
+	}
+
+	bad := []string{"x @Seattle,+WA (0) -> =r\n", "1 (0) -> =a\n1 (0) -> =b\n"}
+	for _, src := range bad {
+		if _, err := DecodeRocketfuel(strings.NewReader(src), nil); err == nil {
+			t.Errorf("Expected error decoding %q", src)
+		}
+	}
+	if _, err := DecodeRocketfuel(strings.NewReader(src), Options{"weights": f.Name() + ".missing"}); err == nil {
+		t.Errorf("Expected error for missing weights file")
+	}
+}
+
+func TestCAIDA(t *testing.T) {
+	// 9 and 10 are top-level providers (and peers), 50 is
+	// a customer of both, and 200, 201 and 202 form a loop
+	// of providers (which appear in the inferred data)
+	src := `# serial-1 and serial-2 lines
+9|90|-1
+9|91|-1
+90|900|-1
+10|100|-1
+9|10|0
+10|50|-1
+9|50|-1
+91|92|1|bgp
+200|201|-1
+201|202|-1
+202|200|-1
+`
+	clusters := func(def GraphDef) map[NodeID]ClusterID {
+		m := make(map[NodeID]ClusterID)
+		for _, n := range def.Nodes {
+			m[n.ID] = n.Cluster
+		}
+		return m
+	}
+
+	def, err := DecodeCAIDA(strings.NewReader(src), nil)
+	if err != nil {
+		t.Fatalf("Error decoding: %v", err)
+	}
+	if len(def.Nodes) != 11 || len(def.Links) != 11 {
+		t.Fatalf("Expected 11 nodes and 11 links; got %+v", def)
+	}
+	for id, c := range clusters(def) {
+		if ClusterID(id) != c {
+			t.Errorf("Expected %v to be in its own cluster; got %v", id, c)
+		}
+	}
+	for i, rel := range []string{"p2c", "p2c", "p2c", "p2c", "p2p", "p2c", "p2c", "s2s"} {
+		if l := def.Links[i]; l.Attrs["relationship"] != rel || l.Cost != 1 {
+			t.Errorf("Expected link %v-%v to have relationship %v; got %+v", l.A, l.B, rel, l)
+		}
+	}
+	if l := def.Links[7]; l.Attrs["source"] != "bgp" {
+		t.Errorf("Expected source to be recorded; got %+v", l)
+	}
+
+	// Each AS follows its lowest-numbered provider
+	// (numerically, so 50 joins 9, not 10), and the
+	// loop joins the cone of its lowest-numbered AS
+	def, err = DecodeCAIDA(strings.NewReader(src), Options{"clusterBy": "cone"})
+	if err != nil {
+		t.Fatalf("Error decoding cones: %v", err)
+	}
+	expected := map[NodeID]ClusterID{
+		"9": "9", "90": "9", "91": "9", "900": "9", "50": "9",
+		"10": "10", "100": "10",
+		"92":  "92",
+		"200": "200", "201": "200", "202": "200",
+	}
+	if c := clusters(def); !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected cones %v; got %v", expected, c)
+	}
+
+	f, err := ioutil.TempFile("", "as2org")
+	if err != nil {
+		t.Fatalf("Error creating as2org file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`# format:org_id|changed|org_name|country|source
+ORG-A|20150801|A Corp|US|ARIN
+# format:aut|changed|aut_name|org_id|opaque_id|source
+9|20150801|A-AS|ORG-A||ARIN
+10|20150801|A-AS2|ORG-A||ARIN
+90|20150801|B-AS|ORG-B||ARIN
+`)
+	f.Close()
+	def, err = DecodeCAIDA(strings.NewReader(src), Options{"clusterBy": "org", "as2org": f.Name()})
+	if err != nil {
+		t.Fatalf("Error decoding organizations: %v", err)
+	}
+	c := clusters(def)
+	if c["9"] != "ORG-A" || c["10"] != "ORG-A" || c["90"] != "ORG-B" || c["91"] != "91" {
+		t.Errorf("Unexpected organization clusters: %v", c)
+	}
+
+	bad := []string{"1|2\n", "1|x|0\n", "1|2|5\n", "1|1|0\n", "1|2|0\n2|1|-1\n"}
+	for _, src := range bad {
+		if _, err := DecodeCAIDA(strings.NewReader(src), nil); err == nil {
+			t.Errorf("Expected error decoding %q", src)
+		}
+	}
+	for _, opts := range []Options{{"clusterBy": "country"}, {"clusterBy": "org"}} {
+		if _, err := DecodeCAIDA(strings.NewReader(src), opts); err == nil {
+			t.Errorf("%v: expected error", opts)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	src := "A B\nB C 3\n"
+	c := Conversion{DefaultFormat: "edge-list", Header: Header{Generator: "test"}}
+	var buf bytes.Buffer
+	stats, err := Convert(&buf, strings.NewReader(src), "", c)
+	if err != nil || stats.Format != "edge-list" || stats.Nodes != 3 || stats.Links != 2 {
+		t.Fatalf("Unexpected result converting edge list: %+v, %v", stats, err)
+	}
+	d := NewDecoder(&buf)
+	h, _ := d.Header()
+	def, err := Decode(d)
+	if err != nil || len(def.Links) != 2 || h.Meta["input"] != "edge-list" {
+		t.Errorf("Unexpected conversion: %+v, %+v (error: %v)", h, def, err)
+	}
+
+	// Relabeling and then restoring the
+	// labels must give back the original
+	c.Preprocess = Preprocessing{Relabel: true}
+	buf.Reset()
+	stats, err = Convert(&buf, strings.NewReader("A\nA B\nD C\n"), "", c)
+	if err != nil || stats.Report.Labels[NodeID("3")] != "D" {
+		t.Fatalf("Unexpected result relabeling: %+v, %v", stats, err)
+	}
+	var labels bytes.Buffer
+	if err := WriteLabels(&labels, stats.Report.Labels); err != nil {
+		t.Fatalf("Error writing labels: %v", err)
+	}
+	rename, err := ReadLabels(&labels)
+	if err != nil {
+		t.Fatalf("Error reading labels: %v", err)
+	}
+	c.Preprocess = Preprocessing{Rename: rename}
+	var restored bytes.Buffer
+	if _, err := Convert(&restored, &buf, "", c); err != nil {
+		t.Fatalf("Error restoring labels: %v", err)
+	}
+	def, _ = Unmarshal(restored.Bytes())
+	def2, _ := DecodeEdgeList(strings.NewReader("A\nA B\nD C\n"), nil)
+	if !reflect.DeepEqual(def, def2.Canonical()) {
+		t.Errorf("Expected restored definition %+v; got %+v", def2.Canonical(), def)
+	}
+	c.Preprocess = Preprocessing{}
+
+	c.DefaultFormat = ""
+	_, err = Convert(ioutil.Discard, strings.NewReader(src), "", c)
+	if cerr, ok := err.(*ConvertError); !ok || cerr.Op != "parse" {
+		t.Errorf("Expected parse error for undetectable format; got %v", err)
+	}
+	c.Format = "json"
+	_, err = Convert(ioutil.Discard, strings.NewReader(src), "", c)
+	if cerr, ok := err.(*ConvertError); !ok || cerr.Op != "parse" {
+		t.Errorf("Expected parse error for wrong format; got %v", err)
+	}
+	c.Stream = true
+	if _, err := Convert(ioutil.Discard, strings.NewReader(src), "", c); err == nil {
+		t.Errorf("Expected error streaming a non-edge-list")
+	}
+	// GraphML is written all at once, so
+	// streaming to it wouldn't bound memory
+	c.Format = "edge-list"
+	if _, err := Convert(ioutil.Discard, strings.NewReader(src), "", c); err != nil {
+		t.Errorf("Unexpected error streaming an edge list: %v", err)
+	}
+	c.OutputFormat = "graphml"
+	if _, err := Convert(ioutil.Discard, strings.NewReader(src), "", c); err == nil {
+		t.Errorf("Expected error streaming to GraphML")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	g := makeTestGraph()
+	g.MergeRound(nil)
+	s := NewSnapshot(g)
+	s.Generator, s.Cost, s.Seed = "test", "MaxCost", 7
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, s); err != nil {
+		t.Fatalf("Error writing snapshot: %v", err)
+	}
+	data := buf.Bytes()
+	s2, err := ReadSnapshot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error reading snapshot: %v", err)
+	}
+	if s2.Round != 1 || s2.Seed != 7 || s2.Generator != "test" {
+		t.Errorf("Unexpected snapshot: %+v", s2)
+	}
+	h, err := s2.Restore(MaxCost)
+	if err != nil {
+		t.Fatalf("Error restoring snapshot: %v", err)
+	}
+	if !g.Equal(h) || h.Rounds() != 1 {
+		t.Errorf("Expected restored graph to be equal after %v rounds; got %v rounds:\n%v", 1, h.Rounds(), h)
+	}
+	g.Merge()
+	h.Merge()
+	if Newick(g.MergeHistory()) != Newick(h.MergeHistory()) {
+		t.Errorf("Expected restored graph to merge identically")
+	}
+
+	// Snapshots can be read as graph definitions
+	d := NewDecoder(bytes.NewReader(data))
+	if hdr, err := d.Header(); err != nil || hdr.Meta["round"] != "1" {
+		t.Errorf("Unexpected snapshot header: %+v (error: %v)", hdr, err)
+	}
+	if def, err := Decode(d); err != nil || Fingerprint(def) != s.Fingerprint {
+		t.Errorf("Unexpected snapshot definition: %+v (error: %v)", def, err)
+	}
+
+	buf.Reset()
+	s2.Graph.Links[0].Cost++
+	WriteSnapshot(&buf, s2)
+	if _, err := ReadSnapshot(&buf); err == nil {
+		t.Errorf("Expected error reading snapshot with the wrong fingerprint")
+	}
+	if _, err := ReadSnapshot(strings.NewReader(`{"Nodes":[],"Links":[]}`)); err == nil {
+		t.Errorf("Expected error reading graph definition as snapshot")
+	}
+}
@@ -0,0 +1,349 @@
+package encoding
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// Edge lists too large to fit in memory (such as the
+// larger SNAP graphs) are converted with an external
+// merge sort. The input is read in chunks of at most
+// chunkSize links and twice as many node mentions (so
+// that inputs made up mostly of isolated nodes or
+// self-links are chunked too); each chunk's links and
+// nodes are sorted, deduplicated, and written
+// to a temporary file (a "run"). The node runs, and
+// then the link runs, are then merged and written to
+// the encoder, so that only one record per run is
+// held in memory at a time. If the input fits in a
+// single chunk, no temporary files are used.
+//
+// Nodes are written in order of their IDs. The
+// cluster file, if any, is held in memory.
+
+// A node (with b empty) or link, along with
+// the line on which it was (first) defined
+type record struct {
+	a, b graph.NodeID
+	cost uint64
+	line uint64
+}
+
+type recordList []record
+
+func (r recordList) Len() int      { return len(r) }
+func (r recordList) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r recordList) Less(i, j int) bool {
+	if r[i].a != r[j].a {
+		return r[i].a < r[j].a
+	}
+	if r[i].b != r[j].b {
+		return r[i].b < r[j].b
+	}
+	return r[i].line < r[j].line
+}
+
+// The default number of links sorted in memory at once
+const defaultChunkSize = 1 << 22
+
+// StreamEdgeList reads an edge list (see DecodeEdgeList)
+// from r and writes it to enc using bounded memory,
+// sorting it on disk if necessary, and then closes enc.
+// Nodes are written in order of their IDs, and links in
+// order of their endpoints. Besides the edge list options,
+// it accepts "chunkSize", the maximum number of links to
+// sort in memory at once (along with at most twice as
+// many node mentions).
+func StreamEdgeList(r io.Reader, enc Encoder, opts Options) error {
+	chunkSize, err := strconv.Atoi(opts.get("chunkSize", strconv.Itoa(defaultChunkSize)))
+	if err != nil || chunkSize < 1 {
+		return fmt.Errorf("bad chunk size: %q", opts["chunkSize"])
+	}
+	clusters, err := readClusterFile(opts.get("clusters", ""))
+	if err != nil {
+		return err
+	}
+
+	dir, err := ioutil.TempDir("", "edgelist")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var nodeRuns, linkRuns []run
+	var nodes, links recordList
+	var selfLinks int
+	// Sort the current chunk, and, unless it
+	// is the last one, spill it to disk
+	flush := func(last bool) error {
+		for _, c := range []struct {
+			recs recordList
+			runs *[]run
+		}{{nodes, &nodeRuns}, {links, &linkRuns}} {
+			sort.Sort(c.recs)
+			if last && len(*c.runs) == 0 {
+				*c.runs = append(*c.runs, &memRun{recs: c.recs})
+				continue
+			}
+			fr, err := spill(dir, c.recs)
+			if err != nil {
+				return err
+			}
+			*c.runs = append(*c.runs, fr)
+		}
+		nodes, links = nodes[:0], links[:0]
+		return nil
+	}
+
+	s := bufio.NewScanner(r)
+	for line := uint64(1); s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || isComment(fields[0]) {
+			continue
+		}
+		l, err := parseEdgeListLine(fields, int(line))
+		if err != nil {
+			return err
+		}
+		if len(links) >= chunkSize || len(nodes) >= 2*chunkSize {
+			// Dedup the nodes before spilling, since
+			// there are usually far fewer of them
+			sort.Sort(nodes)
+			nodes = dedup(nodes)
+			if err := flush(false); err != nil {
+				return err
+			}
+		}
+
+		nodes = append(nodes, record{a: l.A, line: line})
+		if l.B == "" {
+			continue
+		}
+		nodes = append(nodes, record{a: l.B, line: line})
+		if l.A == l.B {
+			selfLinks++
+			continue
+		}
+		if l.B < l.A {
+			l.A, l.B = l.B, l.A
+		}
+		links = append(links, record{l.A, l.B, l.Cost, line})
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if err := flush(true); err != nil {
+		return err
+	}
+	if selfLinks > 0 {
+		warn("Omitting %v illegal self-links", selfLinks)
+	}
+
+	err = merge(nodeRuns, func(rec record, dup bool) error {
+		if dup {
+			return nil
+		}
+		c, ok := clusters[rec.a]
+		if !ok {
+			c = graph.ClusterID(rec.a)
+		}
+		return enc.WriteNode(graph.NodeDef{ID: rec.a, Cluster: c})
+	})
+	if err != nil {
+		return err
+	}
+	var prev record
+	err = merge(linkRuns, func(rec record, dup bool) error {
+		if !dup {
+			prev = rec
+			return enc.WriteLink(graph.LinkDef{A: rec.a, B: rec.b, Cost: rec.cost})
+		}
+		if rec.cost != prev.cost {
+			return fmt.Errorf("line %v: link %v-%v has cost %v, but was given cost %v on line %v",
+				rec.line, rec.a, rec.b, rec.cost, prev.cost, prev.line)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Remove adjacent duplicates from
+// a sorted list of nodes
+func dedup(recs recordList) recordList {
+	out := recs[:0]
+	for i, r := range recs {
+		if i == 0 || r.a != recs[i-1].a {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+/*
+	RUNS
+*/
+
+// A run is a sorted sequence of records
+type run interface {
+	// next returns the next record, or
+	// false once the run is exhausted
+	next() (record, bool, error)
+}
+
+type memRun struct {
+	recs recordList
+}
+
+func (m *memRun) next() (record, bool, error) {
+	if len(m.recs) == 0 {
+		return record{}, false, nil
+	}
+	r := m.recs[0]
+	m.recs = m.recs[1:]
+	return r, true, nil
+}
+
+// Runs are stored on disk as a sequence of
+// records, each encoded as the length-prefixed
+// endpoints followed by the cost and line
+// (all as uvarints)
+type fileRun struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+func spill(dir string, recs recordList) (run, error) {
+	f, err := ioutil.TempFile(dir, "run")
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	var tmp [binary.MaxVarintLen64]byte
+	uvarint := func(x uint64) {
+		w.Write(tmp[:binary.PutUvarint(tmp[:], x)])
+	}
+	for _, r := range recs {
+		uvarint(uint64(len(r.a)))
+		w.WriteString(string(r.a))
+		uvarint(uint64(len(r.b)))
+		w.WriteString(string(r.b))
+		uvarint(r.cost)
+		uvarint(r.line)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileRun{f: f, r: bufio.NewReader(f)}, nil
+}
+
+func (fr *fileRun) next() (record, bool, error) {
+	var r record
+	a, err := fr.str()
+	if err == io.EOF {
+		fr.f.Close()
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, err
+	}
+	b, err := fr.str()
+	if err != nil {
+		return r, false, err
+	}
+	r.a, r.b = graph.NodeID(a), graph.NodeID(b)
+	if r.cost, err = binary.ReadUvarint(fr.r); err != nil {
+		return r, false, err
+	}
+	if r.line, err = binary.ReadUvarint(fr.r); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
+
+func (fr *fileRun) str() (string, error) {
+	n, err := binary.ReadUvarint(fr.r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(fr.r, buf)
+	return string(buf), err
+}
+
+// The head of each run being merged
+type runHeap struct {
+	heads recordList
+	runs  []run
+}
+
+func (h *runHeap) Len() int           { return len(h.heads) }
+func (h *runHeap) Less(i, j int) bool { return h.heads.Less(i, j) }
+func (h *runHeap) Swap(i, j int) {
+	h.heads.Swap(i, j)
+	h.runs[i], h.runs[j] = h.runs[j], h.runs[i]
+}
+func (h *runHeap) Push(x interface{}) { panic("unreachable") }
+func (h *runHeap) Pop() interface{} {
+	n := len(h.heads) - 1
+	h.heads, h.runs = h.heads[:n], h.runs[:n]
+	return nil
+}
+
+// Merge runs, calling f with each record in order.
+// dup is true if the record has the same endpoints
+// as the previous one.
+func merge(runs []run, f func(rec record, dup bool) error) error {
+	h := &runHeap{}
+	for _, r := range runs {
+		rec, ok, err := r.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.heads = append(h.heads, rec)
+			h.runs = append(h.runs, r)
+		}
+	}
+	heap.Init(h)
+
+	var prev record
+	first := true
+	for h.Len() > 0 {
+		rec := h.heads[0]
+		dup := !first && rec.a == prev.a && rec.b == prev.b
+		if err := f(rec, dup); err != nil {
+			return err
+		}
+		prev, first = rec, false
+
+		next, ok, err := h.runs[0].next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.heads[0] = next
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
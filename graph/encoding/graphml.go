@@ -0,0 +1,446 @@
+package encoding
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// GraphML is mapped onto graph definitions as follows:
+//
+//   - Node and edge IDs are taken from the id,
+//     source and target attributes.
+//   - A node's cluster is given by the node data key
+//     named GraphMLClusterKey; nodes without one are
+//     placed in a cluster of their own (named after
+//     the node).
+//   - A link's cost is given by the edge data key named
+//     GraphMLCostKey; links without one have cost 1.
+//     Fractional costs are rounded.
+//   - All other node and edge data keys become
+//     attributes, named by the key's attr.name
+//     (or its id if it has no name).
+//   - The header is stored in graph data keys: the
+//     generator in "generator" and each item of
+//     metadata in "meta.<name>".
+//
+// Self-links are ignored, as are edges which appear
+// in both directions beyond the first.
+const (
+	GraphMLClusterKey = "cluster"
+	GraphMLCostKey    = "cost"
+)
+
+const graphMLMetaPrefix = "meta."
+
+// NewGraphMLDecoder returns a Decoder
+// which reads GraphML from r.
+func NewGraphMLDecoder(r io.Reader) Decoder {
+	return newDecoder(&graphMLDecoder{
+		dec:  xml.NewDecoder(r),
+		keys: make(map[string]graphMLKey),
+	})
+}
+
+type graphMLKey struct {
+	ID      string `xml:"id,attr"`
+	For     string `xml:"for,attr"`
+	Name    string `xml:"attr.name,attr"`
+	Default string `xml:"default"`
+}
+
+// The name by which attributes are known
+func (k graphMLKey) name() string {
+	if k.Name != "" {
+		return k.Name
+	}
+	return k.ID
+}
+
+func (k graphMLKey) appliesTo(elem string) bool {
+	return k.For == elem || k.For == "all" || k.For == ""
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLDecoder struct {
+	dec  *xml.Decoder
+	keys map[string]graphMLKey
+	// Whether the (first) graph element
+	// has been entered and left
+	inGraph, done bool
+	// The first element of the graph
+	// (read while looking for the
+	// graph's data elements)
+	first *Element
+	// Links seen so far, in canonical
+	// order (to drop reversed duplicates)
+	links map[[2]graph.NodeID]bool
+}
+
+func (d *graphMLDecoder) header() (Header, error) {
+	h := Header{Version: CurrentVersion}
+	for !d.done && d.first == nil {
+		tok, err := d.token()
+		if err != nil {
+			return Header{}, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || !d.inGraph || start.Name.Local != "data" {
+			if ok {
+				e, err := d.element(start)
+				if err != nil {
+					return Header{}, err
+				}
+				d.first = e
+			}
+			continue
+		}
+		var data graphMLData
+		if err := d.dec.DecodeElement(&data, &start); err != nil {
+			return Header{}, err
+		}
+		name := d.keyName(data.Key)
+		value := strings.TrimSpace(data.Value)
+		switch {
+		case name == "generator":
+			h.Generator = value
+		case strings.HasPrefix(name, graphMLMetaPrefix):
+			if h.Meta == nil {
+				h.Meta = make(map[string]string)
+			}
+			h.Meta[strings.TrimPrefix(name, graphMLMetaPrefix)] = value
+		}
+	}
+	return h, nil
+}
+
+func (d *graphMLDecoder) next() (Element, error) {
+	if d.first != nil {
+		e := *d.first
+		d.first = nil
+		return e, nil
+	}
+	for !d.done {
+		tok, err := d.token()
+		if err != nil {
+			return Element{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			e, err := d.element(start)
+			if err != nil {
+				return Element{}, err
+			}
+			if e != nil {
+				return *e, nil
+			}
+		}
+	}
+	return Element{}, io.EOF
+}
+
+// Return the next token, keeping track of
+// whether the graph has been entered or left
+func (d *graphMLDecoder) token() (xml.Token, error) {
+	tok, err := d.dec.Token()
+	if err == io.EOF {
+		if !d.inGraph {
+			return nil, fmt.Errorf("no graph element found")
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if t.Name.Local == "graph" {
+			d.inGraph = true
+		}
+	case xml.EndElement:
+		// Nested graphs are consumed along with
+		// the node which contains them, so this
+		// must be the end of the top-level graph
+		if t.Name.Local == "graph" {
+			d.done = true
+		}
+	}
+	return tok, nil
+}
+
+// Handle the start of an element, returning the node or
+// link it defines, if any. Uninteresting elements are
+// skipped in their entirety.
+func (d *graphMLDecoder) element(start xml.StartElement) (*Element, error) {
+	switch start.Name.Local {
+	case "graphml", "graph":
+		// Descend into the element
+		return nil, nil
+	case "key":
+		var k graphMLKey
+		if err := d.dec.DecodeElement(&k, &start); err != nil {
+			return nil, err
+		}
+		d.keys[k.ID] = k
+		return nil, nil
+	case "node":
+		if !d.inGraph {
+			break
+		}
+		var n graphMLNode
+		if err := d.dec.DecodeElement(&n, &start); err != nil {
+			return nil, err
+		}
+		if n.ID == "" {
+			return nil, fmt.Errorf("node with no id")
+		}
+		def := graph.NodeDef{
+			ID:      graph.NodeID(n.ID),
+			Cluster: graph.ClusterID(n.ID),
+		}
+		for name, v := range d.data("node", n.Data) {
+			if name == GraphMLClusterKey {
+				def.Cluster = graph.ClusterID(v)
+				continue
+			}
+			if def.Attrs == nil {
+				def.Attrs = make(map[string]string)
+			}
+			def.Attrs[name] = v
+		}
+		return &Element{Node: &def}, nil
+	case "edge":
+		if !d.inGraph {
+			break
+		}
+		var e graphMLEdge
+		if err := d.dec.DecodeElement(&e, &start); err != nil {
+			return nil, err
+		}
+		if e.Source == "" || e.Target == "" {
+			return nil, fmt.Errorf("edge with missing source or target")
+		}
+		def := graph.LinkDef{A: graph.NodeID(e.Source), B: graph.NodeID(e.Target), Cost: 1}
+		key := [2]graph.NodeID{def.A, def.B}
+		if key[1] < key[0] {
+			key[0], key[1] = key[1], key[0]
+		}
+		if def.A == def.B || d.links[key] {
+			return nil, nil
+		}
+		if d.links == nil {
+			d.links = make(map[[2]graph.NodeID]bool)
+		}
+		d.links[key] = true
+		for name, v := range d.data("edge", e.Data) {
+			if name == GraphMLCostKey {
+				cost, err := parseGraphMLCost(v)
+				if err != nil {
+					return nil, fmt.Errorf("edge %v-%v: %v", e.Source, e.Target, err)
+				}
+				def.Cost = cost
+				continue
+			}
+			if def.Attrs == nil {
+				def.Attrs = make(map[string]string)
+			}
+			def.Attrs[name] = v
+		}
+		return &Element{Link: &def}, nil
+	}
+	return nil, d.dec.Skip()
+}
+
+func (d *graphMLDecoder) keyName(id string) string {
+	if k, ok := d.keys[id]; ok {
+		return k.name()
+	}
+	return id
+}
+
+// Collect the values of the data elements of
+// an element of the given kind, by name,
+// including any defaults.
+func (d *graphMLDecoder) data(elem string, data []graphMLData) map[string]string {
+	vals := make(map[string]string)
+	for _, k := range d.keys {
+		if k.appliesTo(elem) && k.Default != "" {
+			vals[k.name()] = strings.TrimSpace(k.Default)
+		}
+	}
+	for _, dt := range data {
+		vals[d.keyName(dt.Key)] = strings.TrimSpace(dt.Value)
+	}
+	return vals
+}
+
+func parseGraphMLCost(s string) (uint64, error) {
+	if c, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return c, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("bad cost: %q", s)
+	}
+	return uint64(math.Floor(f + 0.5)), nil
+}
+
+type graphMLEncoder struct {
+	w      io.Writer
+	h      Header
+	nodes  []graph.NodeDef
+	links  []graph.LinkDef
+	closed bool
+}
+
+// NewGraphMLEncoder returns an Encoder which writes
+// GraphML to w. Since GraphML requires that all data
+// keys be declared up front, nothing is written
+// until the Encoder is closed.
+func NewGraphMLEncoder(w io.Writer, h Header) Encoder {
+	return &graphMLEncoder{w: w, h: h}
+}
+
+func (e *graphMLEncoder) WriteNode(n graph.NodeDef) error {
+	switch {
+	case e.closed:
+		return fmt.Errorf("encoder closed")
+	case len(e.links) > 0:
+		return fmt.Errorf("cannot write node %v after links", n.ID)
+	}
+	e.nodes = append(e.nodes, n)
+	return nil
+}
+
+func (e *graphMLEncoder) WriteLink(l graph.LinkDef) error {
+	if e.closed {
+		return fmt.Errorf("encoder closed")
+	}
+	e.links = append(e.links, l)
+	return nil
+}
+
+func (e *graphMLEncoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	// Assign key IDs in sorted order so
+	// that the output is deterministic
+	graphKeys := []string{}
+	if e.h.Generator != "" {
+		graphKeys = append(graphKeys, "generator")
+	}
+	for k := range e.h.Meta {
+		graphKeys = append(graphKeys, graphMLMetaPrefix+k)
+	}
+	nodeKeys := []string{GraphMLClusterKey}
+	seen := make(map[string]bool)
+	for _, n := range e.nodes {
+		for k := range n.Attrs {
+			if !seen[k] && k != GraphMLClusterKey {
+				seen[k] = true
+				nodeKeys = append(nodeKeys, k)
+			}
+		}
+	}
+	linkKeys := []string{GraphMLCostKey}
+	seen = make(map[string]bool)
+	for _, l := range e.links {
+		for k := range l.Attrs {
+			if !seen[k] && k != GraphMLCostKey {
+				seen[k] = true
+				linkKeys = append(linkKeys, k)
+			}
+		}
+	}
+	sort.Strings(graphKeys)
+	sort.Strings(nodeKeys[1:])
+	sort.Strings(linkKeys[1:])
+
+	w := bufio.NewWriter(e.w)
+	w.WriteString(xml.Header)
+	w.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	ids := make(map[string]map[string]string)
+	declare := func(elem string, keys []string) {
+		ids[elem] = make(map[string]string)
+		for _, k := range keys {
+			id := fmt.Sprintf("d%v", len(ids["graph"])+len(ids["node"])+len(ids["edge"]))
+			ids[elem][k] = id
+			typ := "string"
+			if elem == "edge" && k == GraphMLCostKey {
+				typ = "long"
+			}
+			fmt.Fprintf(w, "  <key id=%q for=%q attr.name=%s attr.type=%q/>\n", id, elem, xmlQuote(k), typ)
+		}
+	}
+	declare("graph", graphKeys)
+	declare("node", nodeKeys)
+	declare("edge", linkKeys)
+
+	w.WriteString(`  <graph edgedefault="undirected">` + "\n")
+	if e.h.Generator != "" {
+		fmt.Fprintf(w, "    <data key=%q>%s</data>\n", ids["graph"]["generator"], xmlEscape(e.h.Generator))
+	}
+	for _, k := range graphKeys {
+		if strings.HasPrefix(k, graphMLMetaPrefix) {
+			v := e.h.Meta[strings.TrimPrefix(k, graphMLMetaPrefix)]
+			fmt.Fprintf(w, "    <data key=%q>%s</data>\n", ids["graph"][k], xmlEscape(v))
+		}
+	}
+	for _, n := range e.nodes {
+		fmt.Fprintf(w, "    <node id=%s>\n", xmlQuote(string(n.ID)))
+		fmt.Fprintf(w, "      <data key=%q>%s</data>\n", ids["node"][GraphMLClusterKey], xmlEscape(string(n.Cluster)))
+		for _, k := range nodeKeys[1:] {
+			if v, ok := n.Attrs[k]; ok {
+				fmt.Fprintf(w, "      <data key=%q>%s</data>\n", ids["node"][k], xmlEscape(v))
+			}
+		}
+		w.WriteString("    </node>\n")
+	}
+	for _, l := range e.links {
+		fmt.Fprintf(w, "    <edge source=%s target=%s>\n", xmlQuote(string(l.A)), xmlQuote(string(l.B)))
+		fmt.Fprintf(w, "      <data key=%q>%v</data>\n", ids["edge"][GraphMLCostKey], l.Cost)
+		for _, k := range linkKeys[1:] {
+			if v, ok := l.Attrs[k]; ok {
+				fmt.Fprintf(w, "      <data key=%q>%s</data>\n", ids["edge"][k], xmlEscape(v))
+			}
+		}
+		w.WriteString("    </edge>\n")
+	}
+	w.WriteString("  </graph>\n</graphml>\n")
+	e.nodes, e.links = nil, nil
+	return w.Flush()
+}
+
+func xmlEscape(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func xmlQuote(s string) string {
+	return `"` + xmlEscape(s) + `"`
+}
@@ -0,0 +1,62 @@
+package encoding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// Label files record the original IDs of relabeled nodes
+// (see graph.Preprocessing), so that relabeling can be
+// reversed. After a comment line, each line gives a new
+// ID and the original ID, separated by a tab (original IDs
+// may contain spaces):
+//
+//	# label	original
+//	0	node-a
+//	1	node b
+
+// WriteLabels writes a label file mapping
+// the new IDs in labels to the original IDs.
+func WriteLabels(w io.Writer, labels map[graph.NodeID]graph.NodeID) error {
+	ids := make([]string, 0, len(labels))
+	for id := range labels {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# label\toriginal\n")
+	for _, id := range ids {
+		fmt.Fprintf(bw, "%v\t%v\n", id, labels[graph.NodeID(id)])
+	}
+	return bw.Flush()
+}
+
+// ReadLabels reads a label file, returning a map from
+// the new IDs to the original IDs, which can be used
+// as a graph.Preprocessing's Rename to reverse the
+// relabeling.
+func ReadLabels(r io.Reader) (map[graph.NodeID]graph.NodeID, error) {
+	labels := make(map[graph.NodeID]graph.NodeID)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("line %v: expected a label and an original ID separated by a tab", line)
+		}
+		id := graph.NodeID(fields[0])
+		if _, ok := labels[id]; ok {
+			return nil, fmt.Errorf("line %v: duplicate label: %v", line, id)
+		}
+		labels[id] = graph.NodeID(fields[1])
+	}
+	return labels, s.Err()
+}
@@ -0,0 +1,328 @@
+package encoding
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// A Format is a named way of storing graph definitions.
+// Formats are registered with Register; the JSON, binary
+// and GraphML encodings are always registered, along with
+// decoders for snapshots and for the topology datasets
+// described in this package ("edge-list", "zoo",
+// "rocketfuel" and "caida").
+type Format struct {
+	// Name identifies the format (for instance,
+	// in the -format flags of the commands).
+	Name string
+	// Extensions lists the file name suffixes (such
+	// as ".graphml") conventionally used for the format.
+	Extensions []string
+	// Sniff reports whether a definition beginning with
+	// prefix (the first few hundred bytes, after any
+	// decompression) is in this format. It is nil if the
+	// format can't be recognized by its content.
+	Sniff func(prefix []byte) bool
+	// NewDecoder returns a Decoder which reads the format
+	// from r. It is nil if the format can't be read.
+	NewDecoder func(r io.Reader, opts Options) (Decoder, error)
+	// NewEncoder returns an Encoder which writes the format
+	// to w, beginning with h. It is nil if the format can't
+	// be written.
+	NewEncoder func(w io.Writer, h Header) Encoder
+	// Buffered is whether the format's Encoder holds
+	// the whole definition in memory until it is
+	// closed, rather than writing as it goes.
+	Buffered bool
+}
+
+// Options configure a format's decoder. Each format
+// documents the options it accepts; options which a
+// format doesn't use are ignored.
+type Options map[string]string
+
+// Return the option named key, or def if it isn't set
+func (o Options) get(key, def string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Warnings receives warnings about questionable but
+// usable input (such as self-links in an edge list)
+// from decoders. By default, they are discarded;
+// commands set it to os.Stderr to report them.
+var Warnings io.Writer = ioutil.Discard
+
+func warn(format string, args ...interface{}) {
+	fmt.Fprintf(Warnings, format+"\n", args...)
+}
+
+// The number of bytes passed to Sniff functions
+const sniffLen = 512
+
+// Formats in order of registration,
+// which is the order they're sniffed in
+var formats []Format
+
+func init() {
+	Register(Format{
+		Name:       "binary",
+		Extensions: []string{".bin"},
+		Sniff:      func(prefix []byte) bool { return strings.HasPrefix(string(prefix), BinaryMagic) },
+		NewDecoder: func(r io.Reader, opts Options) (Decoder, error) {
+			return newDecoder(&binaryDecoder{r: bufio.NewReader(r)}), nil
+		},
+		NewEncoder: NewBinaryEncoder,
+	})
+	Register(Format{
+		Name:       "graphml",
+		Extensions: []string{".graphml", ".xml"},
+		Sniff:      func(prefix []byte) bool { return firstByte(prefix) == '<' },
+		NewDecoder: func(r io.Reader, opts Options) (Decoder, error) {
+			return NewGraphMLDecoder(r), nil
+		},
+		NewEncoder: NewGraphMLEncoder,
+		Buffered:   true,
+	})
+	Register(snapshotFormat)
+	Register(Format{
+		Name:       "json",
+		Extensions: []string{".def", ".json"},
+		Sniff:      func(prefix []byte) bool { return firstByte(prefix) == '{' },
+		NewDecoder: func(r io.Reader, opts Options) (Decoder, error) {
+			return newDecoder(&jsonDecoder{dec: json.NewDecoder(r)}), nil
+		},
+		NewEncoder: NewEncoder,
+	})
+	Register(Format{
+		Name:       "edge-list",
+		Extensions: []string{".edges", ".el", ".txt"},
+		NewDecoder: defFormat(DecodeEdgeList),
+	})
+	Register(Format{
+		Name:       "zoo",
+		NewDecoder: defFormat(DecodeZoo),
+	})
+	Register(Format{
+		Name:       "rocketfuel",
+		Extensions: []string{".cch"},
+		NewDecoder: defFormat(DecodeRocketfuel),
+	})
+	Register(Format{
+		Name:       "caida",
+		Extensions: []string{".as-rel.txt", ".as-rel2.txt"},
+		NewDecoder: defFormat(DecodeCAIDA),
+	})
+}
+
+// Return the first non-whitespace byte of
+// prefix, or 0 if there is none
+func firstByte(prefix []byte) byte {
+	for _, b := range prefix {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return b
+		}
+	}
+	return 0
+}
+
+// Register makes a format available by name to
+// Lookup, NewFormatDecoder and NewFormatEncoder,
+// and to detection by content and extension. It
+// panics if a format with the same name is already
+// registered.
+func Register(f Format) {
+	if f.Name == "" {
+		panic("encoding: Register of format with no name")
+	}
+	if _, ok := Lookup(f.Name); ok {
+		panic("encoding: Register called twice for format " + f.Name)
+	}
+	formats = append(formats, f)
+}
+
+// Lookup returns the named format.
+func Lookup(name string) (Format, bool) {
+	for _, f := range formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// ByExtension returns the format conventionally used for
+// files with the given name, ignoring any compression
+// extension (see TrimCompressionExt). If more than one
+// format's extension matches, the longest match wins.
+func ByExtension(filename string) (Format, bool) {
+	name := strings.ToLower(TrimCompressionExt(filename))
+	var best Format
+	bestLen := 0
+	for _, f := range formats {
+		for _, ext := range f.Extensions {
+			if strings.HasSuffix(name, ext) && len(ext) > bestLen {
+				best, bestLen = f, len(ext)
+			}
+		}
+	}
+	return best, bestLen > 0
+}
+
+// Sniff returns the first registered format which
+// recognizes prefix (the beginning of a definition,
+// after any decompression).
+func Sniff(prefix []byte) (Format, bool) {
+	for _, f := range formats {
+		if f.Sniff != nil && f.Sniff(prefix) {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// ReadableFormats returns the sorted names of
+// the registered formats which can be read.
+func ReadableFormats() []string {
+	var names []string
+	for _, f := range formats {
+		if f.NewDecoder != nil {
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WritableFormats returns the sorted names of
+// the registered formats which can be written.
+func WritableFormats() []string {
+	var names []string
+	for _, f := range formats {
+		if f.NewEncoder != nil {
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detect decompresses r (see Decompress) and determines
+// the format of the definition it contains, first by
+// sniffing its content and then by the extension of name
+// (the name of the file being read, if known). It returns
+// the format's name (or "" if it couldn't be determined)
+// and a Reader which reads the decompressed definition
+// from the beginning.
+func Detect(r io.Reader, name string) (string, io.Reader, error) {
+	r, err := Decompress(r)
+	if err != nil {
+		return "", nil, err
+	}
+	br := bufio.NewReaderSize(r, sniffLen)
+	// An error here just means the
+	// definition is shorter than sniffLen
+	prefix, _ := br.Peek(sniffLen)
+	if f, ok := Sniff(prefix); ok {
+		return f.Name, br, nil
+	}
+	if f, ok := ByExtension(name); ok {
+		return f.Name, br, nil
+	}
+	return "", br, nil
+}
+
+// NewFormatDecoder returns a Decoder which reads the
+// named format from r with the given options. The
+// definition may be compressed (see Decompress). If
+// format is empty, it is detected as by Detect, using
+// name (which may be empty) as the name of the file
+// being read.
+func NewFormatDecoder(format string, r io.Reader, name string, opts Options) (Decoder, error) {
+	var err error
+	if format == "" {
+		format, r, err = Detect(r, name)
+		if err != nil {
+			return nil, err
+		}
+		if format == "" {
+			return nil, fmt.Errorf("unable to detect format")
+		}
+	} else if r, err = Decompress(r); err != nil {
+		return nil, err
+	}
+	f, ok := Lookup(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %v", format)
+	}
+	if f.NewDecoder == nil {
+		return nil, fmt.Errorf("format %v cannot be read", format)
+	}
+	return f.NewDecoder(r, opts)
+}
+
+// NewFormatEncoder returns an Encoder which writes
+// the named format to w, beginning with h.
+func NewFormatEncoder(format string, w io.Writer, h Header) (Encoder, error) {
+	f, ok := Lookup(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown format: %v", format)
+	}
+	if f.NewEncoder == nil {
+		return nil, fmt.Errorf("format %v cannot be written", format)
+	}
+	return f.NewEncoder(w, h), nil
+}
+
+// Adapt a function which parses an entire
+// definition at once into a decoder constructor
+func defFormat(parse func(r io.Reader, opts Options) (graph.GraphDef, error)) func(io.Reader, Options) (Decoder, error) {
+	return func(r io.Reader, opts Options) (Decoder, error) {
+		def, err := parse(r, opts)
+		if err != nil {
+			return nil, err
+		}
+		return newDecoder(&defDecoder{def: def}), nil
+	}
+}
+
+// defDecoder returns the elements of a GraphDef,
+// along with h (whose version, if unset, is the
+// current one)
+type defDecoder struct {
+	def   graph.GraphDef
+	h     Header
+	nodes int
+	links int
+}
+
+func (d *defDecoder) header() (Header, error) {
+	h := d.h
+	if h.Version == 0 {
+		h.Version = CurrentVersion
+	}
+	return h, nil
+}
+
+func (d *defDecoder) next() (Element, error) {
+	switch {
+	case d.nodes < len(d.def.Nodes):
+		d.nodes++
+		return Element{Node: &d.def.Nodes[d.nodes-1]}, nil
+	case d.links < len(d.def.Links):
+		d.links++
+		return Element{Link: &d.def.Links[d.links-1]}, nil
+	}
+	return Element{}, io.EOF
+}
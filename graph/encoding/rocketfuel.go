@@ -0,0 +1,145 @@
+package encoding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// Rocketfuel (https://research.cs.washington.edu/networking/rocketfuel/)
+// ISP maps are .cch files with one router per line:
+//
+//	uid @loc [+] [bb] (num_neigh) [&ext] -> <nuid-1> <nuid-2> ... {-euid} ... =name[!] rn
+//
+// Lines beginning with a '-' describe routers external
+// to the ISP; these, and links to them, are omitted.
+// The location, name and whether the router is in the
+// backbone are recorded in the "location", "name" and
+// "backbone" node attributes.
+//
+// Rocketfuel doesn't include link weights in .cch files.
+// If a weights file (such as weights.intra from the
+// weights-dist dataset) is named by the "weights"
+// option, each of its lines gives the weight of the links between a pair
+// of locations:
+//
+//	loc1 loc2 weight
+//
+// Links between locations which aren't listed, and
+// links within a location, have cost 1.
+
+// DecodeRocketfuel reads a Rocketfuel ISP map from r.
+func DecodeRocketfuel(r io.Reader, opts Options) (graph.GraphDef, error) {
+	var def graph.GraphDef
+
+	weights, err := readRocketfuelWeights(opts.get("weights", ""))
+	if err != nil {
+		return def, err
+	}
+
+	nodes := make(map[graph.NodeID]bool)
+	locs := make(map[graph.NodeID]string)
+	var links [][2]graph.NodeID
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			return def, fmt.Errorf("line %v: malformed router ID: %q", line, fields[0])
+		}
+		n := graph.NodeDef{
+			ID:      graph.NodeID(fields[0]),
+			Cluster: graph.ClusterID(fields[0]),
+			Attrs:   make(map[string]string),
+		}
+		for _, f := range fields[1:] {
+			switch {
+			case strings.HasPrefix(f, "@"):
+				n.Attrs["location"] = f[1:]
+			case f == "bb":
+				n.Attrs["backbone"] = "true"
+			case strings.HasPrefix(f, "="):
+				n.Attrs["name"] = strings.TrimSuffix(f[1:], "!")
+			case strings.HasPrefix(f, "<") && strings.HasSuffix(f, ">"):
+				nb := graph.NodeID(f[1 : len(f)-1])
+				if nb != n.ID {
+					links = append(links, [2]graph.NodeID{n.ID, nb})
+				}
+			}
+		}
+		if nodes[n.ID] {
+			return def, fmt.Errorf("line %v: duplicate router ID: %v", line, n.ID)
+		}
+		nodes[n.ID] = true
+		locs[n.ID] = n.Attrs["location"]
+		def.Nodes = append(def.Nodes, n)
+	}
+	if err := s.Err(); err != nil {
+		return def, err
+	}
+
+	// Every link is listed by both of its endpoints
+	seen := make(map[[2]graph.NodeID]bool)
+	var dangling int
+	for _, l := range links {
+		if !nodes[l[1]] {
+			dangling++
+			continue
+		}
+		if l[1] < l[0] {
+			l[0], l[1] = l[1], l[0]
+		}
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		cost := uint64(1)
+		if w, ok := weights[[2]string{locs[l[0]], locs[l[1]]}]; ok {
+			cost = w
+		}
+		def.Links = append(def.Links, graph.LinkDef{A: l[0], B: l[1], Cost: cost})
+	}
+	if dangling > 0 {
+		warn("Omitting %v links to undefined routers", dangling)
+	}
+	return def, nil
+}
+
+// Read a weights file, returning the weights of
+// each pair of locations (in both orders).
+func readRocketfuelWeights(filename string) (map[[2]string]uint64, error) {
+	weights := make(map[[2]string]uint64)
+	if filename == "" {
+		return weights, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%v: line %v: expected 3 fields; got %v", filename, i+1, len(fields))
+		}
+		w, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("%v: line %v: bad weight: %q", filename, i+1, fields[2])
+		}
+		// Weights may be fractional
+		cost := uint64(math.Max(1, math.Floor(w+0.5)))
+		weights[[2]string{fields[0], fields[1]}] = cost
+		weights[[2]string{fields[1], fields[0]}] = cost
+	}
+	return weights, nil
+}
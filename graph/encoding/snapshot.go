@@ -0,0 +1,146 @@
+package encoding
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// SnapshotVersion is the version of the snapshot
+// format written by this package.
+const SnapshotVersion = 1
+
+// A Snapshot is the complete state of a simulation
+// after some number of rounds: the graph and its merge
+// history, along with how the simulation was run and
+// what it was run on. A graph restored from a snapshot
+// merges exactly as the original would have.
+//
+// Snapshots are stored as JSON (optionally compressed;
+// see Decompress), and are registered as the read-only
+// "snapshot" format, so that the graph they contain
+// can be read like any other definition.
+type Snapshot struct {
+	// Version is the snapshot format version.
+	// It is always stored first, which is how
+	// snapshots are recognized.
+	Version int `json:"SnapshotVersion"`
+	// Generator names the program which
+	// wrote the snapshot.
+	Generator string `json:",omitempty"`
+	// Round is the number of rounds
+	// which had been performed.
+	Round int
+	// Cost describes the cost function used.
+	Cost string
+	// Seed is the seed of any randomness
+	// in the simulation.
+	Seed int64
+	// Options holds any other settings
+	// which affect the simulation.
+	Options map[string]string `json:",omitempty"`
+	// Source names the graph the simulation was
+	// started from, SourceFingerprint is its
+	// Fingerprint, and SourceHeader is its header.
+	Source            string `json:",omitempty"`
+	SourceFingerprint string `json:",omitempty"`
+	SourceHeader      Header
+	// Fingerprint is the Fingerprint of Graph,
+	// which is checked when the snapshot is read.
+	Fingerprint string
+	History     []*graph.MergeNode
+	Graph       graph.GraphDef
+}
+
+// The beginning of every snapshot
+var snapshotPrefix = []byte(`{"SnapshotVersion":`)
+
+// The read-only "snapshot" format, which reads the
+// graph in a snapshot. Snapshots are JSON, so it
+// must be registered before the JSON format.
+var snapshotFormat = Format{
+	Name:       "snapshot",
+	Extensions: []string{".snap"},
+	Sniff: func(prefix []byte) bool {
+		return bytes.HasPrefix(bytes.TrimLeft(prefix, " \t\r\n"), snapshotPrefix)
+	},
+	NewDecoder: func(r io.Reader, opts Options) (Decoder, error) {
+		s, err := ReadSnapshot(r)
+		if err != nil {
+			return nil, err
+		}
+		h := Header{
+			Generator: s.Generator,
+			Meta: map[string]string{
+				"cost":  s.Cost,
+				"round": fmt.Sprint(s.Round),
+			},
+		}
+		return newDecoder(&defDecoder{def: s.Graph, h: h}), nil
+	},
+}
+
+// NewSnapshot returns a snapshot of g's current
+// state, including its merge history. The caller
+// is expected to fill in the rest of the snapshot.
+func NewSnapshot(g *graph.Graph) Snapshot {
+	def := g.GraphDef().Canonical()
+	return Snapshot{
+		Version:     SnapshotVersion,
+		Round:       g.Rounds(),
+		Fingerprint: Fingerprint(def),
+		History:     g.MergeHistory(),
+		Graph:       def,
+	}
+}
+
+// WriteSnapshot writes s to w.
+func WriteSnapshot(w io.Writer, s Snapshot) error {
+	s.Version = SnapshotVersion
+	bw := bufio.NewWriter(w)
+	if err := json.NewEncoder(bw).Encode(s); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadSnapshot reads a snapshot from r, which
+// may be compressed (see Decompress).
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	r, err := Decompress(r)
+	if err != nil {
+		return s, err
+	}
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return s, err
+	}
+	switch {
+	case s.Version == 0:
+		return s, fmt.Errorf("not a snapshot")
+	case s.Version > SnapshotVersion:
+		return s, fmt.Errorf("unsupported snapshot version %v (newest supported version is %v)", s.Version, SnapshotVersion)
+	}
+	if f := Fingerprint(s.Graph); f != s.Fingerprint {
+		return s, fmt.Errorf("snapshot graph has fingerprint %v, but was written with fingerprint %v", f, s.Fingerprint)
+	}
+	return s, nil
+}
+
+// Restore reconstructs the graph in s, including its
+// merge history and number of rounds, using the cost
+// function c (which should be the one s describes).
+func (s Snapshot) Restore(c graph.Cost) (*graph.Graph, error) {
+	g, err := ReadGraph(newDecoder(&defDecoder{def: s.Graph}), c)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.RestoreHistory(s.History, s.Round); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
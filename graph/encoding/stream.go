@@ -0,0 +1,378 @@
+package encoding
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// An Element is a single node or link of a graph
+// definition. Exactly one of Node and Link is non-nil.
+type Element struct {
+	Node *graph.NodeDef
+	Link *graph.LinkDef
+}
+
+// A Decoder reads a graph definition incrementally,
+// so that the entire encoded definition never has
+// to be held in memory. Definitions stored in older
+// versions of the format are upgraded as they are read.
+type Decoder interface {
+	// Header returns the definition's header.
+	Header() (Header, error)
+	// Next returns the next node or link. Once
+	// all have been read, it returns io.EOF.
+	Next() (Element, error)
+}
+
+// An Encoder writes a graph definition incrementally.
+// All nodes must be written before any links.
+type Encoder interface {
+	WriteNode(n graph.NodeDef) error
+	WriteLink(l graph.LinkDef) error
+	// Close finishes the encoded definition and
+	// flushes any buffered data. It does not close
+	// the underlying writer.
+	Close() error
+}
+
+// Decode reads all remaining nodes and links from d.
+func Decode(d Decoder) (graph.GraphDef, error) {
+	def := graph.GraphDef{
+		Nodes: make([]graph.NodeDef, 0),
+		Links: make([]graph.LinkDef, 0),
+	}
+	for {
+		e, err := d.Next()
+		switch {
+		case err == io.EOF:
+			return def, nil
+		case err != nil:
+			return graph.GraphDef{}, err
+		case e.Node != nil:
+			def.Nodes = append(def.Nodes, *e.Node)
+		default:
+			def.Links = append(def.Links, *e.Link)
+		}
+	}
+}
+
+// Encode writes def to e and closes e.
+func Encode(e Encoder, def graph.GraphDef) error {
+	for _, n := range def.Nodes {
+		if err := e.WriteNode(n); err != nil {
+			return err
+		}
+	}
+	for _, l := range def.Links {
+		if err := e.WriteLink(l); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// ReadGraph constructs a graph from the nodes and links
+// read from d without building an intermediate GraphDef.
+func ReadGraph(d Decoder, c graph.Cost) (*graph.Graph, error) {
+	b := graph.NewBuilder(c)
+	// Links which appear before their endpoints
+	// (the format permits this, though Encoders
+	// never produce it)
+	var pending []graph.LinkDef
+	for {
+		e, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e.Node != nil {
+			b.AddNode(*e.Node)
+		} else if b.AddLink(*e.Link) != nil {
+			pending = append(pending, *e.Link)
+		}
+	}
+	for _, l := range pending {
+		if err := b.AddLink(l); err != nil {
+			return nil, err
+		}
+	}
+	return b.Graph(), nil
+}
+
+// WriteGraph writes g to e and closes e without
+// building an intermediate GraphDef.
+func WriteGraph(e Encoder, g *graph.Graph) error {
+	err := g.Walk(e.WriteNode, e.WriteLink)
+	if err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+/*
+	JSON
+*/
+
+type jsonState int
+
+const (
+	jsonStart jsonState = iota
+	jsonKeys
+	jsonNodes
+	jsonLinks
+	jsonDone
+)
+
+type jsonDecoder struct {
+	dec   *json.Decoder
+	state jsonState
+	h     Header
+	// Whether the Nodes or Links key has been
+	// read (after which the header may not change)
+	sawArrays bool
+}
+
+// NewDecoder returns a Decoder which reads a graph
+// definition from r in any registered format which
+// can be recognized by its content (see Sniff), such
+// as the JSON encoding produced by Marshal, the binary
+// encoding produced by MarshalBinary, or GraphML. The
+// definition may be compressed (see Decompress).
+func NewDecoder(r io.Reader) Decoder {
+	d, err := NewFormatDecoder("", r, "", nil)
+	if err != nil {
+		return newDecoder(errDecoder{err})
+	}
+	return d
+}
+
+type errDecoder struct {
+	err error
+}
+
+func (e errDecoder) header() (Header, error) { return Header{}, e.err }
+func (e errDecoder) next() (Element, error)  { return Element{}, e.err }
+
+func (j *jsonDecoder) header() (Header, error) {
+	// The header consists of the keys
+	// which precede the first element
+	if _, err := j.seek(); err != nil {
+		return Header{}, err
+	}
+	return j.h, nil
+}
+
+func (j *jsonDecoder) next() (Element, error) {
+	ok, err := j.seek()
+	if err != nil {
+		return Element{}, err
+	}
+	if !ok {
+		return Element{}, io.EOF
+	}
+	if j.state == jsonNodes {
+		var n graph.NodeDef
+		if err := j.dec.Decode(&n); err != nil {
+			return Element{}, err
+		}
+		return Element{Node: &n}, nil
+	}
+	var l graph.LinkDef
+	if err := j.dec.Decode(&l); err != nil {
+		return Element{}, err
+	}
+	return Element{Link: &l}, nil
+}
+
+// Consume input until positioned before an element
+// (returning true) or at the end of the input
+// (returning false).
+func (j *jsonDecoder) seek() (bool, error) {
+	for {
+		switch j.state {
+		case jsonStart:
+			if err := j.expect(json.Delim('{')); err != nil {
+				return false, err
+			}
+			j.state = jsonKeys
+		case jsonKeys:
+			if !j.dec.More() {
+				if err := j.expect(json.Delim('}')); err != nil {
+					return false, err
+				}
+				j.state = jsonDone
+				continue
+			}
+			tok, err := j.dec.Token()
+			if err != nil {
+				return false, err
+			}
+			switch tok {
+			case "Nodes", "Links":
+				j.sawArrays = true
+				tok2, err := j.dec.Token()
+				switch {
+				case err != nil:
+					return false, err
+				case tok2 == nil:
+					// null; no elements
+					continue
+				case tok2 != json.Delim('['):
+					return false, fmt.Errorf("expected array for %v; got %v", tok, tok2)
+				}
+				j.state = jsonNodes
+				if tok == "Links" {
+					j.state = jsonLinks
+				}
+			case "Version", "Generator", "Meta":
+				if j.sawArrays {
+					return false, fmt.Errorf("header field %v must precede nodes and links", tok)
+				}
+				var err error
+				switch tok {
+				case "Version":
+					err = j.dec.Decode(&j.h.Version)
+				case "Generator":
+					err = j.dec.Decode(&j.h.Generator)
+				case "Meta":
+					err = j.dec.Decode(&j.h.Meta)
+				}
+				if err != nil {
+					return false, fmt.Errorf("bad header field %v: %v", tok, err)
+				}
+			default:
+				// Skip unknown keys
+				var v json.RawMessage
+				if err := j.dec.Decode(&v); err != nil {
+					return false, err
+				}
+			}
+		case jsonNodes, jsonLinks:
+			if j.dec.More() {
+				return true, nil
+			}
+			if err := j.expect(json.Delim(']')); err != nil {
+				return false, err
+			}
+			j.state = jsonKeys
+		case jsonDone:
+			return false, nil
+		}
+	}
+}
+
+func (j *jsonDecoder) expect(delim json.Delim) error {
+	tok, err := j.dec.Token()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v; got %v", delim, tok)
+	}
+	return nil
+}
+
+type jsonEncoder struct {
+	w     *bufio.Writer
+	h     Header
+	state jsonState
+	// Whether an element has been written
+	// to the current array
+	nonEmpty bool
+}
+
+// NewEncoder returns an Encoder which writes the JSON
+// encoding produced by Marshal to w, beginning with h
+// (whose Version is set to CurrentVersion).
+func NewEncoder(w io.Writer, h Header) Encoder {
+	h.Version = CurrentVersion
+	return &jsonEncoder{w: bufio.NewWriter(w), h: h}
+}
+
+// Write the header and open the nodes array
+func (j *jsonEncoder) begin() error {
+	data, err := json.Marshal(j.h)
+	if err != nil {
+		return err
+	}
+	// Strip the closing brace so that
+	// the header fields are followed
+	// by the nodes and links
+	j.w.Write(data[:len(data)-1])
+	j.w.WriteString(`,"Nodes":[`)
+	j.state = jsonNodes
+	return nil
+}
+
+func (j *jsonEncoder) WriteNode(n graph.NodeDef) error {
+	switch j.state {
+	case jsonStart:
+		if err := j.begin(); err != nil {
+			return err
+		}
+	case jsonLinks:
+		return fmt.Errorf("cannot write node %v after links", n.ID)
+	case jsonDone:
+		return fmt.Errorf("encoder closed")
+	}
+	return j.writeElement(n)
+}
+
+func (j *jsonEncoder) WriteLink(l graph.LinkDef) error {
+	switch j.state {
+	case jsonStart:
+		if err := j.begin(); err != nil {
+			return err
+		}
+		j.w.WriteString(`],"Links":[`)
+	case jsonNodes:
+		j.w.WriteString(`],"Links":[`)
+	case jsonDone:
+		return fmt.Errorf("encoder closed")
+	}
+	if j.state != jsonLinks {
+		j.state = jsonLinks
+		j.nonEmpty = false
+	}
+	return j.writeElement(l)
+}
+
+func (j *jsonEncoder) writeElement(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if j.nonEmpty {
+		j.w.WriteByte(',')
+	}
+	j.nonEmpty = true
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonEncoder) Close() error {
+	switch j.state {
+	case jsonStart:
+		if err := j.begin(); err != nil {
+			return err
+		}
+		j.w.WriteString(`],"Links":[]}`)
+	case jsonNodes:
+		j.w.WriteString(`],"Links":[]}`)
+	case jsonLinks:
+		j.w.WriteString(`]}`)
+	case jsonDone:
+		return nil
+	}
+	j.state = jsonDone
+	return j.w.Flush()
+}
@@ -0,0 +1,111 @@
+package encoding
+
+import "fmt"
+
+// CurrentVersion is the version of the graph definition
+// format written by this package. Older versions are
+// upgraded when read; newer versions are rejected.
+//
+// Version history:
+//
+//	1: A bare GraphDef with no header.
+//	2: A Header precedes the GraphDef.
+const CurrentVersion = 2
+
+// A Header describes an encoded graph definition.
+type Header struct {
+	// Version is the format version of the definition.
+	// When encoding, it is always set to CurrentVersion.
+	// When decoding, it reports the version of the
+	// definition as it was stored (before any migration
+	// was applied).
+	Version int
+	// Generator names the program which
+	// produced the definition.
+	Generator string `json:",omitempty"`
+	// Meta holds arbitrary metadata describing
+	// how the definition was created (such as
+	// the cost function used and the simulation
+	// round).
+	Meta map[string]string `json:",omitempty"`
+}
+
+// A migration upgrades a single element of a
+// definition by one version. migrations[v]
+// upgrades from version v to version v+1.
+var migrations = map[int]func(e *Element) error{
+	// Version 2 only added the header;
+	// elements are unchanged.
+	1: func(e *Element) error { return nil },
+}
+
+func checkVersion(v int) error {
+	switch {
+	case v < 1:
+		return fmt.Errorf("invalid format version %v", v)
+	case v > CurrentVersion:
+		return fmt.Errorf("unsupported format version %v (newest supported version is %v); "+
+			"the file was probably written by a newer version of this software", v, CurrentVersion)
+	}
+	return nil
+}
+
+// Every format's decoder implements rawDecoder;
+// decoder wraps it, taking care of versioning.
+type rawDecoder interface {
+	// header reads the definition's header, if any,
+	// returning the zero Header if there is none.
+	// It is called before next.
+	header() (Header, error)
+	// next returns the next element, as stored
+	next() (Element, error)
+}
+
+type decoder struct {
+	raw     rawDecoder
+	h       Header
+	err     error
+	started bool
+}
+
+func newDecoder(raw rawDecoder) Decoder {
+	return &decoder{raw: raw}
+}
+
+func (d *decoder) start() {
+	if d.started {
+		return
+	}
+	d.started = true
+	d.h, d.err = d.raw.header()
+	if d.err != nil {
+		return
+	}
+	if d.h.Version == 0 {
+		// Headerless definitions predate versioning
+		d.h.Version = 1
+	}
+	d.err = checkVersion(d.h.Version)
+}
+
+func (d *decoder) Header() (Header, error) {
+	d.start()
+	return d.h, d.err
+}
+
+func (d *decoder) Next() (Element, error) {
+	d.start()
+	if d.err != nil {
+		return Element{}, d.err
+	}
+	e, err := d.raw.next()
+	if err != nil {
+		return Element{}, err
+	}
+	for v := d.h.Version; v < CurrentVersion; v++ {
+		if err := migrations[v](&e); err != nil {
+			return Element{}, fmt.Errorf("upgrading from version %v: %v", v, err)
+		}
+	}
+	return e, nil
+}
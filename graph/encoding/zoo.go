@@ -0,0 +1,168 @@
+package encoding
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// Internet Topology Zoo (http://www.topology-zoo.org)
+// graphs are GraphML with the following data keys:
+//
+//	node: Latitude, Longitude, label, Country, Internal
+//	edge: LinkSpeed, LinkSpeedUnits, LinkSpeedRaw (bits/s), LinkLabel
+//
+// Coordinates are mapped to the "lat" and "long" node
+// attributes, labels to "label", and link speeds to the
+// "speed" link attribute (in bits per second). The great
+// circle distance between a link's endpoints (if known)
+// is recorded in the "distance" attribute (in km). All
+// other data keys are carried over unchanged.
+//
+// Link costs are derived according to the "costFrom"
+// option: "speed" (the default; the reference bandwidth
+// given by the "refBandwidth" option, in bits per second,
+// divided by the link speed), "distance" (in km), or
+// "hops" (every link has cost 1). Links whose cost can't
+// be derived have cost 1.
+
+// Earth's mean radius in km
+const earthRadius = 6371.0
+
+// The default reference bandwidth in bits per second
+const defaultRefBandwidth = 100e9
+
+// DecodeZoo reads an Internet Topology Zoo graph from r.
+func DecodeZoo(r io.Reader, opts Options) (graph.GraphDef, error) {
+	costFrom := opts.get("costFrom", "speed")
+	switch costFrom {
+	case "speed", "distance", "hops":
+	default:
+		return graph.GraphDef{}, fmt.Errorf("unknown cost source: %v", costFrom)
+	}
+	refBandwidth := defaultRefBandwidth
+	if s, ok := opts["refBandwidth"]; ok {
+		var err error
+		refBandwidth, err = strconv.ParseFloat(s, 64)
+		if err != nil || refBandwidth <= 0 {
+			return graph.GraphDef{}, fmt.Errorf("bad reference bandwidth: %q", s)
+		}
+	}
+
+	def, err := Decode(NewGraphMLDecoder(r))
+	if err != nil {
+		return def, err
+	}
+
+	type coords struct{ lat, long float64 }
+	locs := make(map[graph.NodeID]coords)
+	for i := range def.Nodes {
+		n := &def.Nodes[i]
+		if n.Attrs == nil {
+			n.Attrs = make(map[string]string)
+		}
+		rename(n.Attrs, "Latitude", "lat")
+		rename(n.Attrs, "Longitude", "long")
+		lat, err1 := strconv.ParseFloat(n.Attrs["lat"], 64)
+		long, err2 := strconv.ParseFloat(n.Attrs["long"], 64)
+		if err1 == nil && err2 == nil {
+			locs[n.ID] = coords{lat, long}
+		}
+	}
+
+	// Links whose cost couldn't be derived
+	var missing int
+	for i := range def.Links {
+		l := &def.Links[i]
+		if l.Attrs == nil {
+			l.Attrs = make(map[string]string)
+		}
+		rename(l.Attrs, "LinkLabel", "label")
+		speed, speedOK := zooLinkSpeed(l.Attrs)
+		if speedOK {
+			l.Attrs["speed"] = strconv.FormatFloat(speed, 'f', -1, 64)
+		}
+		a, aOK := locs[l.A]
+		b, bOK := locs[l.B]
+		dist := haversine(a.lat, a.long, b.lat, b.long)
+		if aOK && bOK {
+			l.Attrs["distance"] = strconv.FormatFloat(dist, 'f', 1, 64)
+		}
+
+		switch {
+		case costFrom == "speed" && speedOK:
+			l.Cost = speedCost(refBandwidth, speed)
+		case costFrom == "distance" && aOK && bOK:
+			l.Cost = distanceCost(dist)
+		case costFrom == "hops":
+			l.Cost = 1
+		default:
+			l.Cost = 1
+			missing++
+		}
+	}
+	if missing > 0 {
+		warn("Using cost 1 for %v of %v links with unknown %v", missing, len(def.Links), costFrom)
+	}
+	return def, nil
+}
+
+// Rename the key old to new, unless new is already set
+func rename(attrs map[string]string, old, new string) {
+	v, ok := attrs[old]
+	if !ok {
+		return
+	}
+	delete(attrs, old)
+	if _, ok := attrs[new]; !ok {
+		attrs[new] = v
+	}
+}
+
+// Return the speed of a link in bits per second
+func zooLinkSpeed(attrs map[string]string) (float64, bool) {
+	if raw, err := strconv.ParseFloat(attrs["LinkSpeedRaw"], 64); err == nil && raw > 0 {
+		return raw, true
+	}
+	speed, err := strconv.ParseFloat(attrs["LinkSpeed"], 64)
+	if err != nil || speed <= 0 {
+		return 0, false
+	}
+	switch strings.ToUpper(attrs["LinkSpeedUnits"]) {
+	case "K":
+		return speed * 1e3, true
+	case "M":
+		return speed * 1e6, true
+	case "G":
+		return speed * 1e9, true
+	case "T":
+		return speed * 1e12, true
+	}
+	return 0, false
+}
+
+// Compute an OSPF-style cost (the reference
+// bandwidth divided by the link speed)
+func speedCost(refBandwidth, speed float64) uint64 {
+	return uint64(math.Max(1, math.Floor(refBandwidth/speed+0.5)))
+}
+
+// Use the distance in km as the cost
+func distanceCost(dist float64) uint64 {
+	return uint64(math.Max(1, math.Floor(dist+0.5)))
+}
+
+// Compute the great circle distance in km between
+// two points given in degrees latitude and longitude
+func haversine(lat1, long1, lat2, long2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dlat := rad(lat2 - lat1)
+	dlong := rad(long2 - long1)
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dlong/2)*math.Sin(dlong/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
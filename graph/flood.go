@@ -0,0 +1,365 @@
+package graph
+
+import (
+	"container/heap"
+	"fmt"
+	"time"
+)
+
+// LinkChange describes a change to the
+// link between two nodes.
+type LinkChange struct {
+	A, B NodeID
+	// If Down is true, the link fails.
+	// Otherwise, the link's cost is set
+	// to Cost (if no such link exists,
+	// it is added).
+	Down bool
+	Cost uint64
+}
+
+// FloodConfig configures a flooding simulation.
+type FloodConfig struct {
+	// Latency returns the one-way latency of the given
+	// link. If it is nil, CostLatency(time.Millisecond)
+	// is used.
+	Latency func(l LinkDef) time.Duration
+	// SPFDelay is the amount of time a node waits after
+	// receiving a new LSA before running SPF, allowing
+	// further LSAs to be batched into the same run.
+	SPFDelay time.Duration
+	// SPFHold is the minimum amount of time between
+	// the starts of consecutive SPF runs on a node.
+	SPFHold time.Duration
+	// SPFTime is the amount of time an SPF run takes.
+	SPFTime time.Duration
+}
+
+// FloodResult describes the outcome of a flooding simulation.
+type FloodResult struct {
+	// ConvergeTime maps each node which received at
+	// least one update to the time at which its last
+	// SPF run completed. Nodes which received no
+	// updates are omitted.
+	ConvergeTime map[NodeID]time.Duration
+	// Converged is the time at which the last node
+	// converged.
+	Converged time.Duration
+	// Messages is the total number of LSA
+	// transmissions, and is the sum of
+	// LocalMessages and OverlayMessages.
+	Messages        int
+	LocalMessages   int
+	OverlayMessages int
+}
+
+// CostLatency returns a latency function (for use
+// in FloodConfig) which gives each link a latency
+// of unit for every unit of cost.
+func CostLatency(unit time.Duration) func(l LinkDef) time.Duration {
+	return func(l LinkDef) time.Duration {
+		return time.Duration(l.Cost) * unit
+	}
+}
+
+// AttrLatency returns a latency function (for use
+// in FloodConfig) which reads each link's latency
+// from the attribute with the given key, which must
+// be parseable by time.ParseDuration. Links without
+// a valid such attribute use fallback.
+func AttrLatency(key string, fallback func(l LinkDef) time.Duration) func(l LinkDef) time.Duration {
+	return func(l LinkDef) time.Duration {
+		if v, ok := l.Attrs[key]; ok {
+			if d, err := time.ParseDuration(v); err == nil {
+				return d
+			}
+		}
+		return fallback(l)
+	}
+}
+
+// SimulateFlood simulates the flooding of link-state updates
+// after the given change, and returns the time it takes each
+// affected node to converge.
+//
+// The simulation respects g's clustering. If the link is
+// internal to a cluster, its endpoints originate updates which
+// are flooded only within that cluster; any of the cluster's
+// border nodes whose distances to the cluster's other border
+// nodes change (changing its virtual links) originates an
+// overlay update once its first SPF run completes. If the link
+// connects two clusters, its endpoints originate overlay updates
+// immediately. Since every node stores a remote LSDB, overlay
+// updates are flooded to the entire graph.
+//
+// Updates are flooded hop by hop, each hop taking the link's
+// latency. If the changed link fails, it isn't used for
+// flooding; otherwise, it is used with its new cost.
+func (g *Graph) SimulateFlood(change LinkChange, cfg FloodConfig) (FloodResult, error) {
+	a, b := g.Node(change.A), g.Node(change.B)
+	switch {
+	case a == nil:
+		return FloodResult{}, fmt.Errorf("no such node: %v", change.A)
+	case b == nil:
+		return FloodResult{}, fmt.Errorf("no such node: %v", change.B)
+	case a == b:
+		return FloodResult{}, fmt.Errorf("illegal self-link for node %v", a.id)
+	}
+	if _, ok := a.edges[b.id]; change.Down && !ok {
+		return FloodResult{}, fmt.Errorf("no such link: %v-%v", a.id, b.id)
+	}
+	if cfg.Latency == nil {
+		cfg.Latency = CostLatency(time.Millisecond)
+	}
+
+	s := &floodSim{
+		cfg:      cfg,
+		adj:      make(map[*Node][]floodHop),
+		state:    make(map[*Node]*floodNodeState),
+		overlays: make(map[*Node]bool),
+	}
+	for _, n := range g.nodes {
+		for _, e := range n.edges {
+			if e.dst == a && n == b || e.dst == b && n == a {
+				// The changed link is added
+				// below unless it failed
+				continue
+			}
+			s.addHop(n, e.dst, e.cost, e.attrs)
+		}
+	}
+	if !change.Down {
+		var attrs map[string]string
+		if e, ok := a.edges[b.id]; ok {
+			attrs = e.attrs
+		}
+		s.addHop(a, b, change.Cost, attrs)
+		s.addHop(b, a, change.Cost, attrs)
+	}
+
+	if a.cluster == b.cluster {
+		s.originate(0, a, a.cluster)
+		s.originate(0, b, a.cluster)
+		for _, n := range s.changedBorderNodes(a.cluster) {
+			s.overlays[n] = true
+		}
+	} else {
+		s.originate(0, a, nil)
+		s.originate(0, b, nil)
+	}
+	s.run()
+
+	res := FloodResult{
+		ConvergeTime:    make(map[NodeID]time.Duration),
+		Messages:        s.local + s.overlay,
+		LocalMessages:   s.local,
+		OverlayMessages: s.overlay,
+	}
+	for n, st := range s.state {
+		res.ConvergeTime[n.id] = st.converged
+		if st.converged > res.Converged {
+			res.Converged = st.converged
+		}
+	}
+	return res, nil
+}
+
+type floodHop struct {
+	dst     *Node
+	cost    uint64
+	latency time.Duration
+}
+
+// A link-state advertisement. If scope is nil, the LSA
+// is flooded over the overlay; otherwise, it is flooded
+// only within scope.
+type lsa struct {
+	origin *Node
+	scope  *Cluster
+}
+
+type floodNodeState struct {
+	received map[*lsa]bool
+
+	spfScheduled bool
+	spfRan       bool
+	lastSPF      time.Duration
+	converged    time.Duration
+}
+
+type floodSim struct {
+	cfg FloodConfig
+	adj map[*Node][]floodHop
+
+	now    time.Duration
+	events floodEventQueue
+	seq    int
+
+	state map[*Node]*floodNodeState
+	// Border nodes which should originate an
+	// overlay LSA after their first SPF run
+	overlays map[*Node]bool
+
+	local, overlay int
+}
+
+type floodEventKind int
+
+const (
+	evReceive floodEventKind = iota
+	evSPFStart
+	evSPFDone
+)
+
+type floodEvent struct {
+	at   time.Duration
+	seq  int
+	kind floodEventKind
+	node *Node
+	lsa  *lsa
+	from *Node
+}
+
+func (s *floodSim) schedule(ev floodEvent) {
+	ev.seq = s.seq
+	s.seq++
+	heap.Push(&s.events, ev)
+}
+
+func (s *floodSim) nodeState(n *Node) *floodNodeState {
+	st, ok := s.state[n]
+	if !ok {
+		st = &floodNodeState{received: make(map[*lsa]bool)}
+		s.state[n] = st
+	}
+	return st
+}
+
+func (s *floodSim) addHop(n, dst *Node, cost uint64, attrs map[string]string) {
+	s.adj[n] = append(s.adj[n], floodHop{
+		dst:     dst,
+		cost:    cost,
+		latency: s.cfg.Latency(LinkDef{A: n.id, B: dst.id, Cost: cost, Attrs: attrs}),
+	})
+}
+
+func (s *floodSim) originate(at time.Duration, n *Node, scope *Cluster) {
+	s.schedule(floodEvent{at: at, kind: evReceive, node: n, lsa: &lsa{n, scope}})
+}
+
+func (s *floodSim) run() {
+	for s.events.Len() > 0 {
+		ev := heap.Pop(&s.events).(floodEvent)
+		s.now = ev.at
+		switch ev.kind {
+		case evReceive:
+			s.receive(ev)
+		case evSPFStart:
+			st := s.nodeState(ev.node)
+			st.spfScheduled = false
+			st.spfRan = true
+			st.lastSPF = s.now
+			s.schedule(floodEvent{at: s.now + s.cfg.SPFTime, kind: evSPFDone, node: ev.node})
+		case evSPFDone:
+			st := s.nodeState(ev.node)
+			if s.now > st.converged {
+				st.converged = s.now
+			}
+			if s.overlays[ev.node] {
+				delete(s.overlays, ev.node)
+				s.originate(s.now, ev.node, nil)
+			}
+		}
+	}
+}
+
+func (s *floodSim) receive(ev floodEvent) {
+	st := s.nodeState(ev.node)
+	if st.received[ev.lsa] {
+		// Duplicate; discard
+		return
+	}
+	st.received[ev.lsa] = true
+
+	for _, h := range s.adj[ev.node] {
+		if h.dst == ev.from {
+			continue
+		}
+		if ev.lsa.scope != nil && h.dst.cluster != ev.lsa.scope {
+			continue
+		}
+		if ev.lsa.scope == nil {
+			s.overlay++
+		} else {
+			s.local++
+		}
+		s.schedule(floodEvent{at: s.now + h.latency, kind: evReceive, node: h.dst, lsa: ev.lsa, from: ev.node})
+	}
+
+	if !st.spfScheduled {
+		at := s.now + s.cfg.SPFDelay
+		if st.spfRan && st.lastSPF+s.cfg.SPFHold > at {
+			at = st.lastSPF + s.cfg.SPFHold
+		}
+		st.spfScheduled = true
+		s.schedule(floodEvent{at: at, kind: evSPFStart, node: ev.node})
+	}
+}
+
+// Return the border nodes of c whose intra-cluster
+// distances to c's other border nodes are altered by
+// the change (which must be internal to c).
+func (s *floodSim) changedBorderNodes(c *Cluster) []*Node {
+	var border []*Node
+	for _, n := range c.members {
+		if n.IsBorderNode() {
+			border = append(border, n)
+		}
+	}
+	if len(border) < 2 {
+		// No virtual links
+		return nil
+	}
+
+	// The flooding adjacencies reflect the change,
+	// while clusterEdges gives the distances before it
+	after := func(n *Node, visit func(dst *Node, cost uint64)) {
+		for _, h := range s.adj[n] {
+			if h.dst.cluster == c {
+				visit(h.dst, h.cost)
+			}
+		}
+	}
+
+	var changed []*Node
+	for _, n := range border {
+		old, cur := shortestPaths(n, clusterEdges), shortestPaths(n, after)
+		for _, m := range border {
+			p, okp := old[m]
+			q, okq := cur[m]
+			if okp != okq || p.dist != q.dist {
+				changed = append(changed, n)
+				break
+			}
+		}
+	}
+	return changed
+}
+
+type floodEventQueue []floodEvent
+
+func (f floodEventQueue) Len() int { return len(f) }
+func (f floodEventQueue) Less(i, j int) bool {
+	if f[i].at != f[j].at {
+		return f[i].at < f[j].at
+	}
+	return f[i].seq < f[j].seq
+}
+func (f floodEventQueue) Swap(i, j int)       { f[i], f[j] = f[j], f[i] }
+func (f *floodEventQueue) Push(x interface{}) { *f = append(*f, x.(floodEvent)) }
+func (f *floodEventQueue) Pop() interface{} {
+	old := *f
+	ev := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return ev
+}
@@ -0,0 +1,278 @@
+// Package generate constructs synthetic network topologies,
+// so that experiments don't depend on finding a suitable
+// real-world graph.
+//
+// Each model is a function which returns a GraphDef given
+// the model's size parameters and a Config, which supplies
+// the random seed, the distribution of link costs, and the
+// initial assignment of nodes to clusters. Given the same
+// arguments, a model always returns the same graph.
+//
+// Models which place nodes in space record each node's
+// coordinates in the "x" and "y" attributes, and models
+// with a natural grouping of nodes (such as the pods of a
+// fat-tree or the PoPs of an ISP) record each node's group
+// in the "group" attribute.
+package generate
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// A Config holds the settings shared by all models.
+type Config struct {
+	// Seed seeds the random number generator
+	// used to build the graph and draw costs.
+	Seed int64
+	// Cost draws the cost of each link. If it
+	// is nil, every link has cost 1.
+	Cost CostDist
+	// Clusters assigns nodes to their initial
+	// clusters. If it is nil, every node is
+	// placed in a cluster of its own.
+	Clusters Clustering
+}
+
+// A CostDist draws the cost of the link between a and b.
+type CostDist func(r *rand.Rand, a, b graph.NodeDef) (uint64, error)
+
+// ParseCostDist parses a description of a cost distribution:
+//
+//	const:C           every link has cost C (a bare number
+//	                  is also accepted)
+//	uniform:LO-HI     costs are uniformly distributed
+//	                  between LO and HI (inclusive)
+//	exp:MEAN          costs are exponentially distributed
+//	                  with the given mean (and at least 1)
+//	distance[:SCALE]  the Euclidean distance between the
+//	                  link's endpoints (which must have
+//	                  coordinates) times SCALE (default 1),
+//	                  rounded to at least 1
+func ParseCostDist(s string) (CostDist, error) {
+	kind, arg := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, arg = s[:i], s[i+1:]
+	}
+	switch kind {
+	case "const":
+	case "uniform":
+		parts := strings.Split(arg, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad uniform cost range: %q", arg)
+		}
+		lo, err1 := strconv.ParseUint(parts[0], 10, 64)
+		hi, err2 := strconv.ParseUint(parts[1], 10, 64)
+		if err1 != nil || err2 != nil || lo < 1 || hi < lo {
+			return nil, fmt.Errorf("bad uniform cost range: %q", arg)
+		}
+		return func(r *rand.Rand, a, b graph.NodeDef) (uint64, error) {
+			return lo + uint64(r.Int63n(int64(hi-lo+1))), nil
+		}, nil
+	case "exp":
+		mean, err := strconv.ParseFloat(arg, 64)
+		if err != nil || mean <= 0 {
+			return nil, fmt.Errorf("bad exponential cost mean: %q", arg)
+		}
+		return func(r *rand.Rand, a, b graph.NodeDef) (uint64, error) {
+			return roundCost(r.ExpFloat64() * mean), nil
+		}, nil
+	case "distance":
+		scale := 1.0
+		if arg != "" {
+			var err error
+			scale, err = strconv.ParseFloat(arg, 64)
+			if err != nil || scale <= 0 {
+				return nil, fmt.Errorf("bad distance scale: %q", arg)
+			}
+		}
+		return func(r *rand.Rand, a, b graph.NodeDef) (uint64, error) {
+			ax, ay, aOK := coords(a)
+			bx, by, bOK := coords(b)
+			if !aOK || !bOK {
+				return 0, fmt.Errorf("cannot use distance costs for link %v-%v: nodes have no coordinates", a.ID, b.ID)
+			}
+			return roundCost(math.Hypot(ax-bx, ay-by) * scale), nil
+		}, nil
+	default:
+		// A bare number
+		arg = s
+	}
+	c, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || c < 1 {
+		return nil, fmt.Errorf("bad cost distribution: %q", s)
+	}
+	return func(r *rand.Rand, a, b graph.NodeDef) (uint64, error) {
+		return c, nil
+	}, nil
+}
+
+func roundCost(c float64) uint64 {
+	return uint64(math.Max(1, math.Floor(c+0.5)))
+}
+
+func coords(n graph.NodeDef) (x, y float64, ok bool) {
+	x, err1 := strconv.ParseFloat(n.Attrs["x"], 64)
+	y, err2 := strconv.ParseFloat(n.Attrs["y"], 64)
+	return x, y, err1 == nil && err2 == nil
+}
+
+// A Clustering assigns each of nodes (in the order
+// in which they were generated) to a cluster.
+type Clustering func(r *rand.Rand, nodes []graph.NodeDef)
+
+// ParseClustering parses a description of an
+// initial cluster assignment:
+//
+//	none      every node is in a cluster of its own
+//	random:K  nodes are assigned to K clusters at random
+//	blocks:K  nodes are divided, in the order in which
+//	          they were generated, into K clusters of
+//	          (nearly) equal size
+//	group     nodes are clustered by their "group"
+//	          attribute (nodes without one are in a
+//	          cluster of their own)
+func ParseClustering(s string) (Clustering, error) {
+	kind, arg := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, arg = s[:i], s[i+1:]
+	}
+	var k int
+	if kind == "random" || kind == "blocks" {
+		var err error
+		k, err = strconv.Atoi(arg)
+		if err != nil || k < 1 {
+			return nil, fmt.Errorf("bad number of clusters: %q", arg)
+		}
+	} else if arg != "" {
+		return nil, fmt.Errorf("bad clustering: %q", s)
+	}
+
+	switch kind {
+	case "none":
+		return nil, nil
+	case "random":
+		return func(r *rand.Rand, nodes []graph.NodeDef) {
+			for i := range nodes {
+				nodes[i].Cluster = graph.ClusterID(fmt.Sprintf("c%v", r.Intn(k)))
+			}
+		}, nil
+	case "blocks":
+		return func(r *rand.Rand, nodes []graph.NodeDef) {
+			for i := range nodes {
+				nodes[i].Cluster = graph.ClusterID(fmt.Sprintf("c%v", i*k/len(nodes)))
+			}
+		}, nil
+	case "group":
+		return func(r *rand.Rand, nodes []graph.NodeDef) {
+			for i, n := range nodes {
+				if g, ok := n.Attrs["group"]; ok {
+					nodes[i].Cluster = graph.ClusterID(g)
+				}
+			}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown clustering: %v", kind)
+}
+
+/*
+	BUILDER
+*/
+
+// A builder accumulates the nodes and links of
+// a model, ignoring duplicate and self-links
+type builder struct {
+	cfg   Config
+	r     *rand.Rand
+	def   graph.GraphDef
+	index map[graph.NodeID]int
+	links map[[2]graph.NodeID]bool
+}
+
+func newBuilder(cfg Config) *builder {
+	return &builder{
+		cfg:   cfg,
+		r:     rand.New(rand.NewSource(cfg.Seed)),
+		index: make(map[graph.NodeID]int),
+		links: make(map[[2]graph.NodeID]bool),
+	}
+}
+
+// Add a node (in a cluster of its own),
+// returning its index
+func (b *builder) node(id string, attrs map[string]string) int {
+	n := graph.NodeDef{ID: graph.NodeID(id), Cluster: graph.ClusterID(id), Attrs: attrs}
+	b.index[n.ID] = len(b.def.Nodes)
+	b.def.Nodes = append(b.def.Nodes, n)
+	return len(b.def.Nodes) - 1
+}
+
+// Add a node with coordinates and
+// (if group is non-empty) a group
+func (b *builder) placedNode(id string, x, y float64, group string) int {
+	attrs := map[string]string{
+		"x": strconv.FormatFloat(x, 'f', 4, 64),
+		"y": strconv.FormatFloat(y, 'f', 4, 64),
+	}
+	if group != "" {
+		attrs["group"] = group
+	}
+	return b.node(id, attrs)
+}
+
+// Add a node in the given group
+func (b *builder) groupNode(id, group string) int {
+	return b.node(id, map[string]string{"group": group})
+}
+
+// Add a link between the nodes with the given
+// indices, returning whether it was added (that
+// is, whether it isn't a duplicate or self-link)
+func (b *builder) link(i, j int) bool {
+	if i == j || b.linked(i, j) {
+		return false
+	}
+	b.links[b.key(i, j)] = true
+	b.def.Links = append(b.def.Links, graph.LinkDef{A: b.def.Nodes[i].ID, B: b.def.Nodes[j].ID})
+	return true
+}
+
+func (b *builder) linked(i, j int) bool {
+	return b.links[b.key(i, j)]
+}
+
+func (b *builder) key(i, j int) [2]graph.NodeID {
+	a, c := b.def.Nodes[i].ID, b.def.Nodes[j].ID
+	if c < a {
+		a, c = c, a
+	}
+	return [2]graph.NodeID{a, c}
+}
+
+// Draw costs and assign clusters,
+// returning the finished definition
+func (b *builder) finish() (graph.GraphDef, error) {
+	for i := range b.def.Links {
+		l := &b.def.Links[i]
+		l.Cost = 1
+		if b.cfg.Cost != nil {
+			var err error
+			l.Cost, err = b.cfg.Cost(b.r, b.def.Nodes[b.index[l.A]], b.def.Nodes[b.index[l.B]])
+			if err != nil {
+				return graph.GraphDef{}, err
+			}
+		}
+	}
+	if b.cfg.Clusters != nil && len(b.def.Nodes) > 0 {
+		b.cfg.Clusters(b.r, b.def.Nodes)
+	}
+	if b.def.Links == nil {
+		b.def.Links = []graph.LinkDef{}
+	}
+	return b.def, nil
+}
@@ -0,0 +1,375 @@
+package generate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/synful/cluster-simulate/graph"
+)
+
+// Return the number of nodes reachable from the first
+func reachable(def GraphDef) int {
+	adj := make(map[NodeID][]NodeID)
+	for _, l := range def.Links {
+		adj[l.A] = append(adj[l.A], l.B)
+		adj[l.B] = append(adj[l.B], l.A)
+	}
+	seen := map[NodeID]bool{def.Nodes[0].ID: true}
+	queue := []NodeID{def.Nodes[0].ID}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, m := range adj[n] {
+			if !seen[m] {
+				seen[m] = true
+				queue = append(queue, m)
+			}
+		}
+	}
+	return len(seen)
+}
+
+// Return the degree of each node and the
+// set of links (keyed in both orientations)
+func adjacency(def GraphDef) (map[NodeID]int, map[[2]NodeID]bool) {
+	degrees := make(map[NodeID]int)
+	links := make(map[[2]NodeID]bool)
+	for _, n := range def.Nodes {
+		degrees[n.ID] = 0
+	}
+	for _, l := range def.Links {
+		degrees[l.A]++
+		degrees[l.B]++
+		links[[2]NodeID{l.A, l.B}], links[[2]NodeID{l.B, l.A}] = true, true
+	}
+	return degrees, links
+}
+
+// Check that every node has the degree given by deg
+func checkDegrees(def GraphDef, deg func(n NodeDef) int) error {
+	degrees, _ := adjacency(def)
+	for _, n := range def.Nodes {
+		if d := deg(n); d != degrees[n.ID] {
+			return fmt.Errorf("expected %v to have degree %v; got %v", n.ID, d, degrees[n.ID])
+		}
+	}
+	return nil
+}
+
+func regular(d int) func(def GraphDef) error {
+	return func(def GraphDef) error {
+		return checkDegrees(def, func(NodeDef) int { return d })
+	}
+}
+
+func TestModels(t *testing.T) {
+	cfg := Config{Seed: 1}
+	for _, test := range []struct {
+		name      string
+		gen       func() (GraphDef, error)
+		nodes     int
+		links     int
+		connected bool
+		check     func(def GraphDef) error
+	}{
+		{"ring", func() (GraphDef, error) { return Ring(10, cfg) }, 10, 10, true, regular(2)},
+		{"grid", func() (GraphDef, error) { return Grid(3, 4, false, cfg) }, 12, 17, true, checkGrid},
+		{"torus", func() (GraphDef, error) { return Grid(3, 4, true, cfg) }, 12, 24, true, regular(4)},
+		{"tree", func() (GraphDef, error) { return Tree(3, 2, cfg) }, 15, 14, true, checkTree},
+		{"fat-tree", func() (GraphDef, error) { return FatTree(4, cfg) }, 20, 32, true, checkFatTree},
+		{"clos", func() (GraphDef, error) { return Clos(6, 2, cfg) }, 8, 12, true, checkClos},
+		{"ba", func() (GraphDef, error) { return BarabasiAlbert(50, 2, cfg) }, 50, 3 + 47*2, true, checkBarabasiAlbert},
+		{"er", func() (GraphDef, error) { return ErdosRenyi(30, 1, cfg) }, 30, 30 * 29 / 2, true, regular(29)},
+		{"er-empty", func() (GraphDef, error) { return ErdosRenyi(30, 0, cfg) }, 30, 0, false, regular(0)},
+		{"isp", func() (GraphDef, error) { return ISP(8, 3, cfg) }, 40, -1, true, checkISP},
+		{"waxman", func() (GraphDef, error) { return Waxman(40, 0.4, 0.4, cfg) }, 40, -1, false, checkWaxman},
+	} {
+		def, err := test.gen()
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(def.Nodes) != test.nodes || (test.links >= 0 && len(def.Links) != test.links) {
+			t.Errorf("%v: expected %v nodes and %v links; got %v and %v", test.name, test.nodes, test.links, len(def.Nodes), len(def.Links))
+		}
+		if test.connected && reachable(def) != len(def.Nodes) {
+			t.Errorf("%v: expected graph to be connected", test.name)
+		}
+		if _, links := adjacency(def); len(links) != 2*len(def.Links) {
+			t.Errorf("%v: expected no duplicate links", test.name)
+		}
+		if err := test.check(def); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+
+		def2, _ := test.gen()
+		if !reflect.DeepEqual(def, def2) {
+			t.Errorf("%v: expected the same seed to produce the same graph", test.name)
+		}
+	}
+
+	if _, err := FatTree(3, cfg); err == nil {
+		t.Errorf("Expected error for odd fat-tree arity")
+	}
+	if _, err := Tree(1e8, 1, cfg); err == nil {
+		t.Errorf("Expected error for too deep a tree")
+	}
+	if _, err := Tree(2, 1<<40, cfg); err == nil {
+		t.Errorf("Expected error for too wide a tree")
+	}
+	if def, err := Tree(1000, 1, cfg); err != nil || len(def.Nodes) != 1001 {
+		t.Errorf("Expected a path of 1001 nodes; got %v nodes (error: %v)", len(def.Nodes), err)
+	}
+	if _, err := BarabasiAlbert(2, 2, cfg); err == nil {
+		t.Errorf("Expected error for too few nodes")
+	}
+}
+
+// A 3x4 grid has 4 corners, 6 other nodes
+// on its edges, and 2 interior nodes
+func checkGrid(def GraphDef) error {
+	return checkDegrees(def, func(n NodeDef) int {
+		var r, c int
+		fmt.Sscanf(string(n.ID), "%d-%d", &r, &c)
+		d := 4
+		if r == 0 || r == 2 {
+			d--
+		}
+		if c == 0 || c == 3 {
+			d--
+		}
+		return d
+	})
+}
+
+// The root has 2 children, the 6 other internal
+// nodes have a parent and 2 children, and the 8
+// leaves (at depth 3) have only a parent
+func checkTree(def GraphDef) error {
+	return checkDegrees(def, func(n NodeDef) int {
+		switch strings.Count(string(n.ID), ".") {
+		case 0:
+			return 2
+		case 3:
+			return 1
+		}
+		return 3
+	})
+}
+
+// Each of the 4 pods has 2 aggregation and 2 edge
+// switches, with every edge switch linked to both of
+// its pod's aggregation switches, and each of the 4
+// core switches is linked to one aggregation switch
+// in every pod. No other links cross pods.
+func checkFatTree(def GraphDef) error {
+	groups := make(map[NodeID]string)
+	sizes := make(map[string]int)
+	for _, n := range def.Nodes {
+		groups[n.ID] = n.Attrs["group"]
+		sizes[n.Attrs["group"]]++
+	}
+	expected := map[string]int{"core": 4, "pod0": 4, "pod1": 4, "pod2": 4, "pod3": 4}
+	if !reflect.DeepEqual(sizes, expected) {
+		return fmt.Errorf("expected groups %v; got %v", expected, sizes)
+	}
+	corePods := make(map[NodeID]map[string]bool)
+	for _, l := range def.Links {
+		a, b := l.A, l.B
+		if groups[b] == "core" {
+			a, b = b, a
+		}
+		switch {
+		case groups[a] == "core" && strings.Contains(string(b), "-agg"):
+			if corePods[a] == nil {
+				corePods[a] = make(map[string]bool)
+			}
+			corePods[a][groups[b]] = true
+		case groups[a] == groups[b] && strings.Contains(string(a), "-agg") != strings.Contains(string(b), "-agg"):
+		default:
+			return fmt.Errorf("unexpected link %v-%v", l.A, l.B)
+		}
+	}
+	for c, pods := range corePods {
+		if len(pods) != 4 {
+			return fmt.Errorf("expected %v to be linked to every pod; got %v", c, pods)
+		}
+	}
+	return checkDegrees(def, func(n NodeDef) int {
+		if strings.Contains(string(n.ID), "-edge") {
+			return 2
+		}
+		return 4
+	})
+}
+
+// Every leaf is linked to exactly the 2 spines
+func checkClos(def GraphDef) error {
+	for _, l := range def.Links {
+		if strings.HasPrefix(string(l.A), "leaf") == strings.HasPrefix(string(l.B), "leaf") {
+			return fmt.Errorf("unexpected link %v-%v", l.A, l.B)
+		}
+	}
+	return checkDegrees(def, func(n NodeDef) int {
+		if strings.HasPrefix(string(n.ID), "leaf") {
+			return 2
+		}
+		return 6
+	})
+}
+
+// The first 3 nodes form a clique, and every later
+// node links to exactly 2 earlier nodes. Preferential
+// attachment gives the earliest nodes much higher
+// degrees than the latest ones.
+func checkBarabasiAlbert(def GraphDef) error {
+	index := func(id NodeID) int {
+		var i int
+		fmt.Sscanf(string(id), "n%d", &i)
+		return i
+	}
+	earlier := make(map[int]int)
+	for _, l := range def.Links {
+		a, b := index(l.A), index(l.B)
+		if a < b {
+			a = b
+		}
+		earlier[a]++
+	}
+	for i := 1; i < 50; i++ {
+		expected := 2
+		if i < 3 {
+			expected = i
+		}
+		if earlier[i] != expected {
+			return fmt.Errorf("expected n%v to link to %v earlier nodes; got %v", i, expected, earlier[i])
+		}
+	}
+	degrees, _ := adjacency(def)
+	first, last := 0, 0
+	for id, d := range degrees {
+		if index(id) < 10 {
+			first += d
+		} else if index(id) >= 40 {
+			last += d
+		}
+	}
+	if first < 2*last {
+		return fmt.Errorf("expected the first 10 nodes to have much higher degrees than the last 10; got total degrees %v and %v", first, last)
+	}
+	return nil
+}
+
+// Each PoP has 2 linked core routers and 3 access
+// routers linked to both of them, and backbone links
+// come in pairs between like core routers of PoPs
+func checkISP(def GraphDef) error {
+	_, links := adjacency(def)
+	pops := make(map[string]int)
+	for _, n := range def.Nodes {
+		pops[n.Attrs["group"]]++
+	}
+	if len(pops) != 8 {
+		return fmt.Errorf("expected 8 PoPs; got %v", pops)
+	}
+	for p := range pops {
+		if pops[p] != 5 {
+			return fmt.Errorf("expected 5 routers in %v; got %v", p, pops[p])
+		}
+		cr0, cr1 := NodeID(p+"-cr0"), NodeID(p+"-cr1")
+		if !links[[2]NodeID{cr0, cr1}] {
+			return fmt.Errorf("expected the core routers of %v to be linked", p)
+		}
+		for i := 0; i < 3; i++ {
+			ar := NodeID(fmt.Sprintf("%v-ar%v", p, i))
+			if !links[[2]NodeID{ar, cr0}] || !links[[2]NodeID{ar, cr1}] {
+				return fmt.Errorf("expected %v to be linked to both core routers", ar)
+			}
+		}
+	}
+	degrees, _ := adjacency(def)
+	for _, l := range def.Links {
+		a, b := string(l.A), string(l.B)
+		pa, pb := a[:strings.Index(a, "-")], b[:strings.Index(b, "-")]
+		if pa == pb {
+			continue
+		}
+		if a[len(pa):] != b[len(pb):] || !strings.Contains(a, "-cr") {
+			return fmt.Errorf("unexpected backbone link %v-%v", a, b)
+		}
+		other := map[string]string{"-cr0": "-cr1", "-cr1": "-cr0"}[a[len(pa):]]
+		if !links[[2]NodeID{NodeID(pa + other), NodeID(pb + other)}] {
+			return fmt.Errorf("expected backbone link %v-%v to have a parallel link", a, b)
+		}
+	}
+	for id, d := range degrees {
+		if strings.Contains(string(id), "-ar") && d != 2 {
+			return fmt.Errorf("expected access router %v to have degree 2; got %v", id, d)
+		}
+	}
+	return nil
+}
+
+// Nodes are placed in the 1000x1000 square
+func checkWaxman(def GraphDef) error {
+	for _, n := range def.Nodes {
+		x, errx := strconv.ParseFloat(n.Attrs["x"], 64)
+		y, erry := strconv.ParseFloat(n.Attrs["y"], 64)
+		if errx != nil || erry != nil || x < 0 || x > 1000 || y < 0 || y > 1000 {
+			return fmt.Errorf("expected %v to be placed in the square; got %v", n.ID, n.Attrs)
+		}
+	}
+	return nil
+}
+
+func TestConfig(t *testing.T) {
+	cost, err := ParseCostDist("uniform:5-7")
+	if err != nil {
+		t.Fatalf("Error parsing cost distribution: %v", err)
+	}
+	clusters, err := ParseClustering("group")
+	if err != nil {
+		t.Fatalf("Error parsing clustering: %v", err)
+	}
+	def, err := FatTree(4, Config{Cost: cost, Clusters: clusters})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, l := range def.Links {
+		if l.Cost < 5 || l.Cost > 7 {
+			t.Errorf("Expected cost in [5, 7]; got %v", l.Cost)
+		}
+	}
+	if g := NewGraph(def, MaxCost); g.NumClusters() != 5 {
+		t.Errorf("Expected 5 clusters (4 pods and the core); got %v", g.NumClusters())
+	}
+
+	cost, _ = ParseCostDist("distance:2")
+	def, err = Grid(2, 2, false, Config{Cost: cost})
+	if err != nil || def.Links[0].Cost != 2 {
+		t.Errorf("Expected distance costs of 2; got %+v (error: %v)", def.Links, err)
+	}
+	if _, err := Ring(3, Config{Cost: cost}); err == nil {
+		t.Errorf("Expected error using distance costs without coordinates")
+	}
+
+	clusters, _ = ParseClustering("blocks:3")
+	def, _ = Ring(9, Config{Clusters: clusters})
+	if g := NewGraph(def, MaxCost); g.NumClusters() != 3 {
+		t.Errorf("Expected 3 clusters; got %v", g.NumClusters())
+	}
+
+	for _, s := range []string{"", "0", "uniform:3-2", "exp:-1", "gaussian:1"} {
+		if _, err := ParseCostDist(s); err == nil {
+			t.Errorf("Expected error parsing cost distribution %q", s)
+		}
+	}
+	for _, s := range []string{"", "random", "random:0", "none:3", "blocks:x"} {
+		if _, err := ParseClustering(s); err == nil {
+			t.Errorf("Expected error parsing clustering %q", s)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package generate
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// The side of the square in which
+// Waxman and ISP nodes are placed
+const area = 1000.0
+
+// ErdosRenyi returns a G(n, p) random graph: each
+// of the possible links between n nodes is present
+// independently with probability p.
+func ErdosRenyi(n int, p float64, cfg Config) (graph.GraphDef, error) {
+	if n < 1 {
+		return graph.GraphDef{}, fmt.Errorf("number of nodes must be positive")
+	}
+	if p < 0 || p > 1 {
+		return graph.GraphDef{}, fmt.Errorf("link probability must be in [0, 1]")
+	}
+	b := newBuilder(cfg)
+	for i := 0; i < n; i++ {
+		b.node(fmt.Sprintf("n%v", i), nil)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			if b.r.Float64() < p {
+				b.link(i, j)
+			}
+		}
+	}
+	return b.finish()
+}
+
+// BarabasiAlbert returns a scale-free graph of n nodes
+// grown by preferential attachment. It starts with a
+// clique of m+1 nodes; each subsequent node links to m
+// distinct existing nodes, chosen with probability
+// proportional to their degree.
+func BarabasiAlbert(n, m int, cfg Config) (graph.GraphDef, error) {
+	if m < 1 || n <= m {
+		return graph.GraphDef{}, fmt.Errorf("need at least 1 link per node and more nodes than links per node")
+	}
+	b := newBuilder(cfg)
+	// Every endpoint of every link, so that choosing
+	// uniformly from it is choosing by degree
+	var ends []int
+	for i := 0; i <= m; i++ {
+		b.node(fmt.Sprintf("n%v", i), nil)
+		for j := 0; j < i; j++ {
+			b.link(i, j)
+			ends = append(ends, i, j)
+		}
+	}
+	for i := m + 1; i < n; i++ {
+		b.node(fmt.Sprintf("n%v", i), nil)
+		// Choose from the links that existed before
+		// this node, in the order they were chosen
+		existing := len(ends)
+		for added := 0; added < m; {
+			j := ends[b.r.Intn(existing)]
+			if b.link(i, j) {
+				ends = append(ends, i, j)
+				added++
+			}
+		}
+	}
+	return b.finish()
+}
+
+// Waxman returns a Waxman random graph of n nodes placed
+// uniformly at random in a square of side 1000. Nodes u
+// and v are linked with probability
+//
+//	beta * exp(-d(u, v) / (alpha * L))
+//
+// where L is the largest possible distance. Larger alpha
+// makes long links more likely; larger beta makes all
+// links more likely.
+func Waxman(n int, alpha, beta float64, cfg Config) (graph.GraphDef, error) {
+	if n < 1 {
+		return graph.GraphDef{}, fmt.Errorf("number of nodes must be positive")
+	}
+	if alpha <= 0 || beta <= 0 || beta > 1 {
+		return graph.GraphDef{}, fmt.Errorf("alpha must be positive and beta must be in (0, 1]")
+	}
+	b := newBuilder(cfg)
+	xs, ys := make([]float64, n), make([]float64, n)
+	for i := 0; i < n; i++ {
+		xs[i], ys[i] = b.r.Float64()*area, b.r.Float64()*area
+		b.placedNode(fmt.Sprintf("n%v", i), xs[i], ys[i], "")
+	}
+	l := area * math.Sqrt2
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			d := math.Hypot(xs[i]-xs[j], ys[i]-ys[j])
+			if b.r.Float64() < beta*math.Exp(-d/(alpha*l)) {
+				b.link(i, j)
+			}
+		}
+	}
+	return b.finish()
+}
+
+// Grid returns a rows by cols grid, with each node linked
+// to the nodes above, below, and beside it. If torus is
+// true, the edges of the grid wrap around. Each node is
+// named "row-col" and placed at (col, row).
+func Grid(rows, cols int, torus bool, cfg Config) (graph.GraphDef, error) {
+	if rows < 1 || cols < 1 {
+		return graph.GraphDef{}, fmt.Errorf("grid dimensions must be positive")
+	}
+	b := newBuilder(cfg)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			b.placedNode(fmt.Sprintf("%v-%v", r, c), float64(c), float64(r), "")
+		}
+	}
+	at := func(r, c int) int { return r*cols + c }
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			switch {
+			case c+1 < cols:
+				b.link(at(r, c), at(r, c+1))
+			case torus:
+				b.link(at(r, c), at(r, 0))
+			}
+			switch {
+			case r+1 < rows:
+				b.link(at(r, c), at(r+1, c))
+			case torus:
+				b.link(at(r, c), at(0, c))
+			}
+		}
+	}
+	return b.finish()
+}
+
+// Ring returns a cycle of n nodes.
+func Ring(n int, cfg Config) (graph.GraphDef, error) {
+	if n < 1 {
+		return graph.GraphDef{}, fmt.Errorf("number of nodes must be positive")
+	}
+	b := newBuilder(cfg)
+	for i := 0; i < n; i++ {
+		b.node(fmt.Sprintf("n%v", i), nil)
+	}
+	for i := 0; i < n; i++ {
+		b.link(i, (i+1)%n)
+	}
+	return b.finish()
+}
+
+// Tree returns a complete tree of the given depth (a
+// single node has depth 0) in which every internal node
+// has fanout children. The root is named "0", and the
+// children of node X are named "X.0", "X.1", and so on.
+// Each node's group is the subtree of the root it is in.
+func Tree(depth, fanout int, cfg Config) (graph.GraphDef, error) {
+	if depth < 0 || fanout < 1 {
+		return graph.GraphDef{}, fmt.Errorf("depth must be non-negative and fanout positive")
+	}
+	// Bound the total number of nodes (not just the
+	// number of leaves, which is 1 if fanout is 1)
+	total, level := 1, 1
+	for d := 0; d < depth; d++ {
+		if level > 1e7/fanout {
+			return graph.GraphDef{}, fmt.Errorf("tree is too large")
+		}
+		level *= fanout
+		total += level
+		if total > 1e7 {
+			return graph.GraphDef{}, fmt.Errorf("tree is too large")
+		}
+	}
+
+	b := newBuilder(cfg)
+
+	// Build the tree depth-first with an explicit
+	// stack rather than by recursion, which could
+	// overflow the stack for deep trees.
+	type pending struct {
+		parent    int
+		id, group string
+		d         int
+	}
+	var stack []pending
+	push := func(parent int, id, group string, d int) {
+		// Push in reverse so that children
+		// are popped in order
+		for c := fanout - 1; c >= 0; c-- {
+			cid := fmt.Sprintf("%v.%v", id, c)
+			g := group
+			if g == "" {
+				g = cid
+			}
+			stack = append(stack, pending{parent, cid, g, d})
+		}
+	}
+	root := b.node("0", nil)
+	if depth > 0 {
+		push(root, "0", "", 1)
+	}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		child := b.groupNode(p.id, p.group)
+		b.link(p.parent, child)
+		if p.d < depth {
+			push(child, p.id, p.group, p.d+1)
+		}
+	}
+	return b.finish()
+}
+
+// FatTree returns a k-ary fat-tree data center fabric
+// (without hosts): k pods, each of k/2 edge switches
+// ("pP-edgeI") and k/2 aggregation switches ("pP-aggI")
+// with a link between every edge and aggregation switch,
+// and (k/2)^2 core switches ("coreI"), where the Ith
+// aggregation switch of every pod links to core switches
+// I*k/2 through (I+1)*k/2-1. Each switch's group is its
+// pod ("podP"), or "core".
+func FatTree(k int, cfg Config) (graph.GraphDef, error) {
+	if k < 2 || k%2 != 0 {
+		return graph.GraphDef{}, fmt.Errorf("fat-tree arity must be even and at least 2")
+	}
+	b := newBuilder(cfg)
+	half := k / 2
+	cores := make([]int, half*half)
+	for i := range cores {
+		cores[i] = b.groupNode(fmt.Sprintf("core%v", i), "core")
+	}
+	for p := 0; p < k; p++ {
+		group := fmt.Sprintf("pod%v", p)
+		aggs := make([]int, half)
+		for i := range aggs {
+			aggs[i] = b.groupNode(fmt.Sprintf("p%v-agg%v", p, i), group)
+			for j := 0; j < half; j++ {
+				b.link(aggs[i], cores[i*half+j])
+			}
+		}
+		for i := 0; i < half; i++ {
+			edge := b.groupNode(fmt.Sprintf("p%v-edge%v", p, i), group)
+			for _, agg := range aggs {
+				b.link(edge, agg)
+			}
+		}
+	}
+	return b.finish()
+}
+
+// Clos returns a two-stage (leaf-spine) folded Clos
+// fabric in which every one of the given number of
+// leaf switches ("leafI") links to every spine switch
+// ("spineI"). Each switch's group is "leaf" or "spine".
+func Clos(leaves, spines int, cfg Config) (graph.GraphDef, error) {
+	if leaves < 1 || spines < 1 {
+		return graph.GraphDef{}, fmt.Errorf("numbers of leaves and spines must be positive")
+	}
+	b := newBuilder(cfg)
+	ss := make([]int, spines)
+	for i := range ss {
+		ss[i] = b.groupNode(fmt.Sprintf("spine%v", i), "spine")
+	}
+	for i := 0; i < leaves; i++ {
+		leaf := b.groupNode(fmt.Sprintf("leaf%v", i), "leaf")
+		for _, s := range ss {
+			b.link(leaf, s)
+		}
+	}
+	return b.finish()
+}
+
+// ISP returns an ISP-style topology of pops points of
+// presence placed uniformly at random in a square of
+// side 1000. Each PoP has two core routers ("popP-cr0"
+// and "popP-cr1"), which are linked to each other, and
+// access routers ("popP-arI") linked to both cores. The
+// backbone connecting the PoPs is a minimum spanning
+// tree (by distance) with an extra link from each PoP
+// to its nearest PoP not already adjacent to it; each
+// backbone link is a pair of parallel links between the
+// PoPs' first and second core routers. Routers are placed
+// near their PoP's location and grouped by PoP ("popP").
+func ISP(pops, access int, cfg Config) (graph.GraphDef, error) {
+	if pops < 1 || access < 0 {
+		return graph.GraphDef{}, fmt.Errorf("number of PoPs must be positive and number of access routers non-negative")
+	}
+	b := newBuilder(cfg)
+	xs, ys := make([]float64, pops), make([]float64, pops)
+	for p := range xs {
+		xs[p], ys[p] = b.r.Float64()*area, b.r.Float64()*area
+	}
+	// Routers are placed within a few
+	// units of their PoP's location
+	near := func(p int) (float64, float64) {
+		return xs[p] + b.r.Float64()*10 - 5, ys[p] + b.r.Float64()*10 - 5
+	}
+	cores := make([][2]int, pops)
+	for p := 0; p < pops; p++ {
+		group := fmt.Sprintf("pop%v", p)
+		for i := range cores[p] {
+			x, y := near(p)
+			cores[p][i] = b.placedNode(fmt.Sprintf("pop%v-cr%v", p, i), x, y, group)
+		}
+		b.link(cores[p][0], cores[p][1])
+		for i := 0; i < access; i++ {
+			x, y := near(p)
+			ar := b.placedNode(fmt.Sprintf("pop%v-ar%v", p, i), x, y, group)
+			b.link(ar, cores[p][0])
+			b.link(ar, cores[p][1])
+		}
+	}
+
+	dist := func(p, q int) float64 { return math.Hypot(xs[p]-xs[q], ys[p]-ys[q]) }
+	backbone := func(p, q int) {
+		b.link(cores[p][0], cores[q][0])
+		b.link(cores[p][1], cores[q][1])
+	}
+	// Prim's algorithm
+	inTree := make([]bool, pops)
+	best := make([]float64, pops)
+	from := make([]int, pops)
+	for p := range best {
+		best[p] = math.Inf(1)
+	}
+	best[0] = 0
+	for range xs {
+		p := -1
+		for q := range xs {
+			if !inTree[q] && (p < 0 || best[q] < best[p]) {
+				p = q
+			}
+		}
+		inTree[p] = true
+		if p != 0 {
+			backbone(from[p], p)
+		}
+		for q := range xs {
+			if d := dist(p, q); !inTree[q] && d < best[q] {
+				best[q], from[q] = d, p
+			}
+		}
+	}
+	for p := range xs {
+		q := -1
+		for r := range xs {
+			if r != p && !b.linked(cores[p][0], cores[r][0]) && (q < 0 || dist(p, r) < dist(p, q)) {
+				q = r
+			}
+		}
+		if q >= 0 {
+			backbone(p, q)
+		}
+	}
+	return b.finish()
+}
@@ -14,6 +14,12 @@ type Graph struct {
 	nodes    graphNodeMap
 	clusters graphClusterMap
 	costFn   Cost
+
+	// The number of merge rounds performed, and
+	// the merge history of each current cluster
+	// (nil until the first round)
+	rounds  int
+	history map[ClusterID]*MergeNode
 }
 
 // Cluster returns the cluster with the given cluster ID,
@@ -90,6 +96,51 @@ func (g *Graph) RemoteLSDBSize(c ClusterID) int {
 	return g.OverlayLSDBSize() - g.clusters[c].NumVirtEdges()
 }
 
+// NodeLSDBSize returns the number of links stored by
+// the node with the given node ID, or 0 if no such node
+// exists. Every node stores its cluster's local LSDB.
+// Border nodes, which participate in the overlay, also
+// store the entire overlay LSDB, while all other nodes
+// store only their cluster's remote LSDB.
+func (g *Graph) NodeLSDBSize(n NodeID) int {
+	nd := g.Node(n)
+	if nd == nil {
+		return 0
+	}
+	c := nd.cluster
+	if nd.IsBorderNode() {
+		return c.LocalLSDBSize() + g.OverlayLSDBSize()
+	}
+	return c.LocalLSDBSize() + g.RemoteLSDBSize(c.id)
+}
+
+// TotalLSDBSize returns the total number of links
+// stored by all of g's nodes (that is, the sum of
+// NodeLSDBSize over all nodes).
+func (g *Graph) TotalLSDBSize() int {
+	overlay := g.OverlayLSDBSize()
+	total := 0
+	for _, c := range g.clusters {
+		border := c.NumBorderNodes()
+		remote := overlay - c.NumVirtEdges()
+		total += c.NumNodes() * c.LocalLSDBSize()
+		total += border*overlay + (c.NumNodes()-border)*remote
+	}
+	return total
+}
+
+// FlatLSDBSize returns the total number of links
+// which would be stored by all of g's nodes if g
+// were not clustered (that is, if every node stored
+// every link reachable from it).
+func (g *Graph) FlatLSDBSize() int {
+	total := 0
+	for _, c := range g.components() {
+		total += c.nodes * c.links
+	}
+	return total
+}
+
 // Equal tests whether g and h are equal as defined
 // by having the same topology with the same cluster
 // IDs, node IDs, and edge weights. Empty clusters
@@ -185,6 +236,7 @@ type Node struct {
 	id      NodeID
 	cluster *Cluster
 	edges   edgeMap
+	attrs   map[string]string
 }
 
 // NodeID returns n's node ID.
@@ -197,6 +249,13 @@ func (n *Node) ClusterID() ClusterID {
 	return n.cluster.id
 }
 
+// Attr returns the value of n's attribute
+// with the given key, if it exists.
+func (n *Node) Attr(key string) (string, bool) {
+	v, ok := n.attrs[key]
+	return v, ok
+}
+
 // Equal returns whether n and m are equal as defined
 // by having equal node IDs, and equal edges of equal
 // lengths to nodes with the same node IDs.
@@ -1,8 +1,12 @@
 package graph
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func makeTestGraph() *Graph {
@@ -17,21 +21,21 @@ func makeTestGraph() *Graph {
 	*/
 	def := GraphDef{
 		Nodes: []NodeDef{
-			NodeDef{"A", "C1"},
-			NodeDef{"B", "C1"},
-			NodeDef{"C", "C1"},
-			NodeDef{"D", "C2"},
-			NodeDef{"E", "C2"},
-			NodeDef{"F", "C3"},
+			NodeDef{ID: "A", Cluster: "C1"},
+			NodeDef{ID: "B", Cluster: "C1"},
+			NodeDef{ID: "C", Cluster: "C1"},
+			NodeDef{ID: "D", Cluster: "C2"},
+			NodeDef{ID: "E", Cluster: "C2"},
+			NodeDef{ID: "F", Cluster: "C3"},
 		},
 		Links: []LinkDef{
-			LinkDef{"A", "B", 1},
-			LinkDef{"B", "C", 2},
-			LinkDef{"C", "A", 3},
-			LinkDef{"C", "D", 4},
-			LinkDef{"D", "E", 5},
-			LinkDef{"E", "F", 6},
-			LinkDef{"F", "D", 7},
+			LinkDef{A: "A", B: "B", Cost: 1},
+			LinkDef{A: "B", B: "C", Cost: 2},
+			LinkDef{A: "C", B: "A", Cost: 3},
+			LinkDef{A: "C", B: "D", Cost: 4},
+			LinkDef{A: "D", B: "E", Cost: 5},
+			LinkDef{A: "E", B: "F", Cost: 6},
+			LinkDef{A: "F", B: "D", Cost: 7},
 		},
 	}
 
@@ -50,21 +54,21 @@ func makeTestGraphNoClusters() *Graph {
 	*/
 	def := GraphDef{
 		Nodes: []NodeDef{
-			NodeDef{"A", "C1"},
-			NodeDef{"B", "C2"},
-			NodeDef{"C", "C3"},
-			NodeDef{"D", "C4"},
-			NodeDef{"E", "C5"},
-			NodeDef{"F", "C6"},
+			NodeDef{ID: "A", Cluster: "C1"},
+			NodeDef{ID: "B", Cluster: "C2"},
+			NodeDef{ID: "C", Cluster: "C3"},
+			NodeDef{ID: "D", Cluster: "C4"},
+			NodeDef{ID: "E", Cluster: "C5"},
+			NodeDef{ID: "F", Cluster: "C6"},
 		},
 		Links: []LinkDef{
-			LinkDef{"A", "B", 1},
-			LinkDef{"B", "C", 2},
-			LinkDef{"C", "A", 3},
-			LinkDef{"C", "D", 4},
-			LinkDef{"D", "E", 5},
-			LinkDef{"E", "F", 6},
-			LinkDef{"F", "D", 7},
+			LinkDef{A: "A", B: "B", Cost: 1},
+			LinkDef{A: "B", B: "C", Cost: 2},
+			LinkDef{A: "C", B: "A", Cost: 3},
+			LinkDef{A: "C", B: "D", Cost: 4},
+			LinkDef{A: "D", B: "E", Cost: 5},
+			LinkDef{A: "E", B: "F", Cost: 6},
+			LinkDef{A: "F", B: "D", Cost: 7},
 		},
 	}
 
@@ -74,7 +78,7 @@ func makeTestGraphNoClusters() *Graph {
 func TestNumEdges(t *testing.T) {
 	c := Cluster{}
 	if c.cachedNumEdges != nil {
-		t.Errorf("Expected nil cachedNumEdges; got %v", c.cachedNumEdges)
+		t.Errorf("Expected nil cachedNumEdges; got non-nil")
 	}
 	c.NumEdges()
 	if c.cachedNumEdges == nil {
@@ -88,6 +92,27 @@ func TestMakeGraph(t *testing.T) {
 	fmt.Println()
 }
 
+func TestDuplicateNodes(t *testing.T) {
+	def := GraphDef{
+		Nodes: []NodeDef{
+			NodeDef{ID: "A", Cluster: "C1"},
+			NodeDef{ID: "B", Cluster: "C2"},
+			NodeDef{ID: "A", Cluster: "C2"},
+		},
+		Links: []LinkDef{LinkDef{A: "A", B: "B", Cost: 1}},
+	}
+	g := NewGraph(def, MaxCost)
+	if n := len(g.Clusters()); n != 1 {
+		t.Errorf("Expected 1 cluster; got %v", n)
+	}
+	if n := g.Cluster("C2").NumNodes(); n != 2 {
+		t.Errorf("Expected 2 nodes in cluster C2; got %v", n)
+	}
+	if !reflect.DeepEqual(g.GraphDef(), def.Canonical()) {
+		t.Errorf("Expected %v; got %v", def.Canonical(), g.GraphDef())
+	}
+}
+
 func TestGraphEqual(t *testing.T) {
 	g, h := makeTestGraph(), makeTestGraph()
 	if !g.Equal(h) {
@@ -157,3 +182,450 @@ func TestCluster(t *testing.T) {
 	g.Merge()
 	fmt.Println(g)
 }
+
+func TestDistributedMerge(t *testing.T) {
+	cfgs := []NetworkConfig{
+		{},
+		{Delay: time.Millisecond, Jitter: time.Millisecond, Seed: 1},
+		{Delay: time.Millisecond, Loss: 0.3, RetransmitTimeout: 5 * time.Millisecond, Seed: 2},
+	}
+	for _, cfg := range cfgs {
+		g, h := makeTestGraphNoClusters(), makeTestGraphNoClusters()
+		for {
+			var gMerges, hMerges []string
+			changedG := g.MergeRound(func(c, d ClusterID) {
+				gMerges = append(gMerges, fmt.Sprint(c, d))
+			})
+			changedH, stats, err := h.DistributedMergeRound(cfg, func(c, d ClusterID) {
+				hMerges = append(hMerges, fmt.Sprint(c, d))
+			})
+			if err != nil {
+				t.Fatalf("%+v: unexpected error: %v", cfg, err)
+			}
+			if changedG != changedH {
+				t.Fatalf("%+v: expected changed = %v; got %v", cfg, changedG, changedH)
+			}
+			if !g.Equal(h) {
+				t.Fatalf("%+v: expected graphs to be equal; were not: \ng:\n%vh:\n%v", cfg, g, h)
+			}
+			if len(gMerges) != len(hMerges) {
+				t.Errorf("%+v: expected %v merges; got %v", cfg, gMerges, hMerges)
+			}
+			if cfg.Loss == 0 && stats.Retransmissions != 0 {
+				t.Errorf("%+v: expected no retransmissions on a perfect network; got %v", cfg, stats.Retransmissions)
+			}
+			if !changedG {
+				break
+			}
+		}
+	}
+}
+
+func TestSimulateFlood(t *testing.T) {
+	g := makeTestGraph()
+	cfg := FloodConfig{SPFDelay: 10 * time.Millisecond}
+
+	// A-B is internal to C1, which has only one
+	// border node (C), so the update should
+	// never leave C1.
+	res, err := g.SimulateFlood(LinkChange{A: "A", B: "B", Down: true}, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(res.ConvergeTime) != 3 {
+		t.Errorf("Expected 3 nodes to converge; got %v", res.ConvergeTime)
+	}
+	if res.LocalMessages != 4 || res.OverlayMessages != 0 {
+		t.Errorf("Expected 4 local and 0 overlay messages; got %v and %v", res.LocalMessages, res.OverlayMessages)
+	}
+	// C first hears from B after 2ms, and A's update
+	// (after 3ms) is batched into the same SPF run.
+	if res.ConvergeTime["C"] != 12*time.Millisecond {
+		t.Errorf("Expected C to converge after 12ms; got %v", res.ConvergeTime["C"])
+	}
+
+	// C-D connects C1 and C2, so the update
+	// should be flooded everywhere.
+	res, err = g.SimulateFlood(LinkChange{A: "C", B: "D", Down: true}, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.LocalMessages != 0 || res.OverlayMessages == 0 {
+		t.Errorf("Expected only overlay messages; got %v local and %v overlay", res.LocalMessages, res.OverlayMessages)
+	}
+	if len(res.ConvergeTime) != 6 {
+		t.Errorf("Expected 6 nodes to converge; got %v", res.ConvergeTime)
+	}
+
+	// A cost change leaves the link up, so each
+	// endpoint's update is flooded across it
+	// (rather than staying on its side of what
+	// would otherwise look like a partition).
+	res, err = g.SimulateFlood(LinkChange{A: "C", B: "D", Cost: 10}, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.OverlayMessages != 18 {
+		t.Errorf("Expected 18 overlay messages; got %v", res.OverlayMessages)
+	}
+	// F first hears from D after 7ms, then from C
+	// (via D) after 17ms, just as its first SPF run
+	// starts, so it needs a second one.
+	if res.ConvergeTime["F"] != 27*time.Millisecond {
+		t.Errorf("Expected F to converge after 27ms; got %v", res.ConvergeTime["F"])
+	}
+
+	if _, err := g.SimulateFlood(LinkChange{A: "A", B: "F", Down: true}, cfg); err == nil {
+		t.Errorf("Expected error for nonexistent link")
+	}
+}
+
+func TestRoutingTable(t *testing.T) {
+	g := makeTestGraph()
+	r := g.RoutingTable("A")
+	if len(r.Intra) != 2 || len(r.Inter) != 2 || r.Size() != 4 {
+		t.Fatalf("Expected 2 intra-cluster and 2 inter-cluster routes; got %+v", r)
+	}
+	if route := r.Intra["C"]; route.Cost != 3 {
+		t.Errorf("Expected route to C with cost 3; got %+v", route)
+	}
+	if route := r.Inter["C2"]; route.Cost != 7 || route.Exit != "C" {
+		t.Errorf("Expected route to C2 with cost 7 via C; got %+v", route)
+	}
+	if route := r.Inter["C3"]; route.Cost != 14 || route.Exit != "C" {
+		t.Errorf("Expected route to C3 with cost 14 via C; got %+v", route)
+	}
+
+	total := 0
+	for _, size := range g.FIBSizes() {
+		total += size
+	}
+	// C1: 3 nodes * (2 + 2), C2: 2 nodes * (1 + 2), C3: 1 node * (0 + 2)
+	if total != 20 {
+		t.Errorf("Expected 20 FIB entries; got %v", total)
+	}
+	if flat := g.FlatFIBSize(); flat != 30 {
+		t.Errorf("Expected 30 flat FIB entries; got %v", flat)
+	}
+}
+
+func TestLSDBSizes(t *testing.T) {
+	g := makeTestGraph()
+	// Overlay: 3 border edges (C-D, D-F and E-F)
+	// plus 1 virtual edge (D-E in C2)
+	if size := g.OverlayLSDBSize(); size != 4 {
+		t.Fatalf("Expected overlay LSDB size 4; got %v", size)
+	}
+	// A is internal: 3 local + 4 remote
+	if size := g.NodeLSDBSize("A"); size != 7 {
+		t.Errorf("Expected A to store 7 links; got %v", size)
+	}
+	// D is a border node: 1 local + 4 overlay
+	if size := g.NodeLSDBSize("D"); size != 5 {
+		t.Errorf("Expected D to store 5 links; got %v", size)
+	}
+
+	total := 0
+	for n := range g.Nodes() {
+		total += g.NodeLSDBSize(n)
+	}
+	if size := g.TotalLSDBSize(); size != total {
+		t.Errorf("Expected total LSDB size %v; got %v", total, size)
+	}
+	if size := g.FlatLSDBSize(); size != 42 {
+		t.Errorf("Expected flat LSDB size 42; got %v", size)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	g := makeTestGraph()
+	var buf bytes.Buffer
+	if err := g.WriteDOT(&buf, DOTOptions{}); err != nil {
+		t.Fatalf("Error writing DOT: %v", err)
+	}
+	out := buf.String()
+	for _, s := range []string{
+		`subgraph "cluster_C1" {`,
+		`"A" -- "B" [label="1"];`,
+		`"C" [style=filled, fillcolor=lightgrey];`,
+		`"C" -- "D" [label="4", style=dashed, color=red];`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected output to contain %q; got:\n%v", s, out)
+		}
+	}
+	var buf2 bytes.Buffer
+	makeTestGraph().WriteDOT(&buf2, DOTOptions{})
+	if buf2.String() != out {
+		t.Errorf("Expected output to be deterministic")
+	}
+
+	buf.Reset()
+	if err := g.WriteDOT(&buf, DOTOptions{Collapse: true}); err != nil {
+		t.Fatalf("Error writing DOT: %v", err)
+	}
+	out = buf.String()
+	for _, s := range []string{
+		`"C2" -- "C3" [label="6 (2 links)"];`,
+		`"C1" -- "C2" [label="4"];`,
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("Expected output to contain %q; got:\n%v", s, out)
+		}
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	g, h := makeTestGraphNoClusters(), makeTestGraphNoClusters()
+	rounds := g.Merge()
+	h.DistributedMergeCallback(NetworkConfig{}, nil, nil, nil)
+
+	roots := g.MergeHistory()
+	if len(roots) != g.NumClusters() {
+		t.Fatalf("Expected %v roots; got %v", g.NumClusters(), len(roots))
+	}
+	final := Cut(roots, rounds)
+	if len(final) != g.NumNodes() {
+		t.Errorf("Expected %v original clusters; got %v", g.NumNodes(), len(final))
+	}
+	// Every node started in its own cluster
+	orig := map[NodeID]ClusterID{"A": "C1", "B": "C2", "C": "C3", "D": "C4", "E": "C5", "F": "C6"}
+	for n, c := range orig {
+		if cid := g.Node(n).ClusterID(); final[c] != cid {
+			t.Errorf("Expected cluster %v to have been merged into %v; got %v", c, cid, final[c])
+		}
+	}
+	for n, c := range Cut(roots, 0) {
+		if n != c {
+			t.Errorf("Expected %v to be unmerged before the first round; got %v", n, c)
+		}
+	}
+
+	if s, s2 := Newick(roots), Newick(h.MergeHistory()); s != s2 {
+		t.Errorf("Expected distributed history to match; got:\n%v\nand:\n%v", s, s2)
+	}
+	if s := Newick([]*MergeNode{{Cluster: "A", Round: 2, Children: []*MergeNode{{Cluster: "A"}, {Cluster: "b c"}}}}); s != "(A:2,'b c':2)A:0;\n" {
+		t.Errorf("Unexpected Newick output: %q", s)
+	}
+
+	// Costs depend on the rest of the graph, so they
+	// must not depend on the order in which a round's
+	// merges are performed. On a 10x10 grid, many
+	// merges are performed in each round.
+	var def GraphDef
+	for i := 0; i < 100; i++ {
+		id := NodeID(fmt.Sprint(i))
+		def.Nodes = append(def.Nodes, NodeDef{ID: id, Cluster: ClusterID(id)})
+		if i%10 != 9 {
+			def.Links = append(def.Links, LinkDef{A: id, B: NodeID(fmt.Sprint(i + 1)), Cost: uint64(1 + i%7)})
+		}
+		if i < 90 {
+			def.Links = append(def.Links, LinkDef{A: id, B: NodeID(fmt.Sprint(i + 10)), Cost: uint64(1 + i%5)})
+		}
+	}
+	var first []byte
+	for i := 0; i < 5; i++ {
+		g := NewGraph(def, MaxCost)
+		if i%2 == 0 {
+			g.Merge()
+		} else {
+			g.DistributedMergeCallback(NetworkConfig{}, nil, nil, nil)
+		}
+		data, err := HistoryJSON(g.MergeHistory())
+		if err != nil {
+			t.Fatalf("Error encoding history: %v", err)
+		}
+		if first == nil {
+			first = data
+		} else if !bytes.Equal(data, first) {
+			t.Fatalf("Expected identical history JSON every time; got:\n%s\nand:\n%s", first, data)
+		}
+	}
+}
+
+func TestRestoreHistory(t *testing.T) {
+	g := makeTestGraphNoClusters()
+	g.Merge()
+
+	// Save the state after the first round, rebuild
+	// it, and finish merging from there
+	h := makeTestGraphNoClusters()
+	h.MergeRound(nil)
+	saved := NewGraph(h.GraphDef(), MaxCost)
+	if err := saved.RestoreHistory(h.MergeHistory(), h.Rounds()); err != nil {
+		t.Fatalf("Error restoring history: %v", err)
+	}
+	saved.Merge()
+	if saved.Rounds() != g.Rounds() {
+		t.Errorf("Expected %v rounds; got %v", g.Rounds(), saved.Rounds())
+	}
+	if s, s2 := Newick(g.MergeHistory()), Newick(saved.MergeHistory()); s != s2 {
+		t.Errorf("Expected restored history to match; got:\n%v\nand:\n%v", s, s2)
+	}
+
+	if err := NewGraph(h.GraphDef(), MaxCost).RestoreHistory(g.MergeHistory(), g.Rounds()); err == nil {
+		t.Errorf("Expected error restoring history with the wrong clusters")
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	node := func(id string) NodeDef { return NodeDef{ID: NodeID(id), Cluster: ClusterID(id)} }
+	def := GraphDef{
+		Nodes: []NodeDef{node("x"), node("y"), node("z"), node("p"), node("q"), node("s"), node("x")},
+		Links: []LinkDef{
+			{A: "x", B: "y", Cost: 1},
+			{A: "y", B: "z", Cost: 1},
+			{A: "z", B: "z", Cost: 1},
+			{A: "y", B: "x", Cost: 2},
+			{A: "p", B: "q", Cost: 1},
+		},
+	}
+	out, r, err := Preprocess(def, Preprocessing{DropSelfLinks: true, DropDuplicates: true, LargestComponent: true, Relabel: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r.SelfLinks != 1 || r.DuplicateNodes != 1 || r.DuplicateLinks != 1 {
+		t.Errorf("Unexpected duplicate counts: %+v", r)
+	}
+	if r.Components != 3 || r.RemovedComponents != 2 || r.RemovedNodes != 3 || r.RemovedLinks != 1 {
+		t.Errorf("Unexpected component counts: %+v", r)
+	}
+	expect := GraphDef{
+		// The last definition of x is kept, in its place
+		Nodes: []NodeDef{node("1"), node("2"), node("0")},
+		Links: []LinkDef{{A: "1", B: "2", Cost: 1}, {A: "1", B: "0", Cost: 2}},
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Errorf("Expected %+v; got %+v", expect, out)
+	}
+	if len(def.Nodes) != 7 || def.Links[2].A != "z" {
+		t.Errorf("Expected the original definition to be unmodified")
+	}
+
+	// Renaming with the labels undoes relabeling
+	back, _, err := Preprocess(out, Preprocessing{Rename: r.Labels})
+	if err != nil || back.Nodes[2].ID != "x" || back.Nodes[2].Cluster != "x" || back.Links[1].B != "x" {
+		t.Errorf("Unexpected result restoring labels: %+v (error: %v)", back, err)
+	}
+
+	_, r, _ = Preprocess(def, Preprocessing{MinComponentSize: 2})
+	if r.RemovedComponents != 1 || r.RemovedNodes != 1 {
+		t.Errorf("Expected only the isolated node to be removed; got %+v", r)
+	}
+	if _, _, err := Preprocess(def, Preprocessing{Rename: map[NodeID]NodeID{"x": "y"}}); err == nil {
+		t.Errorf("Expected error renaming two nodes to the same ID")
+	}
+	if _, _, err := Preprocess(GraphDef{Links: []LinkDef{{A: "a", B: "b"}}}, Preprocessing{}); err == nil {
+		t.Errorf("Expected error for link to undefined node")
+	}
+
+	// Members of a merged cluster follow the
+	// node whose ID the cluster has
+	merged := GraphDef{
+		Nodes: []NodeDef{node("A"), {ID: "B", Cluster: "A"}, node("C")},
+		Links: []LinkDef{{A: "A", B: "B", Cost: 1}, {A: "B", B: "C", Cost: 1}},
+	}
+	out, r, err = Preprocess(merged, Preprocessing{Relabel: true})
+	if err != nil {
+		t.Fatalf("Unexpected error relabeling merged graph: %v", err)
+	}
+	expect = GraphDef{
+		Nodes: []NodeDef{node("0"), {ID: "1", Cluster: "0"}, node("2")},
+		Links: []LinkDef{{A: "0", B: "1", Cost: 1}, {A: "1", B: "2", Cost: 1}},
+	}
+	if !reflect.DeepEqual(out, expect) {
+		t.Errorf("Expected %+v; got %+v", expect, out)
+	}
+	if g1, g2 := NewGraph(merged, MaxCost), NewGraph(out, MaxCost); g1.NumClusters() != g2.NumClusters() {
+		t.Errorf("Expected %v clusters after relabeling; got %v", g1.NumClusters(), g2.NumClusters())
+	}
+	back, _, err = Preprocess(out, Preprocessing{Rename: r.Labels})
+	if err != nil || !reflect.DeepEqual(back, merged) {
+		t.Errorf("Expected restoring labels to give %+v; got %+v (error: %v)", merged, back, err)
+	}
+	// Cluster X would be renamed C, which
+	// is already the ID of another cluster
+	clash := GraphDef{Nodes: []NodeDef{node("X"), {ID: "Y", Cluster: "C"}}}
+	if _, _, err := Preprocess(clash, Preprocessing{Rename: map[NodeID]NodeID{"X": "C"}}); err == nil {
+		t.Errorf("Expected error renaming a cluster to the ID of another")
+	}
+}
+
+func TestReduce(t *testing.T) {
+	node := func(id string) NodeDef { return NodeDef{ID: NodeID(id), Cluster: ClusterID(id)} }
+	// A complete graph of A, B, C and D, with a
+	// chain A-p-q-C and a stub tree B-s-t
+	def := GraphDef{
+		Nodes: []NodeDef{node("A"), node("B"), node("C"), node("D"), node("p"), node("q"), node("s"), node("t")},
+		Links: []LinkDef{
+			{A: "A", B: "B", Cost: 1},
+			{A: "A", B: "C", Cost: 1},
+			{A: "A", B: "D", Cost: 1},
+			{A: "B", B: "C", Cost: 1},
+			{A: "B", B: "D", Cost: 1},
+			{A: "C", B: "D", Cost: 1},
+			{A: "A", B: "p", Cost: 1},
+			{A: "p", B: "q", Cost: 1},
+			{A: "q", B: "C", Cost: 5},
+			{A: "B", B: "s", Cost: 1},
+			{A: "s", B: "t", Cost: 1},
+		},
+	}
+	reduced, r, err := Reduce(def)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := GraphDef{Nodes: []NodeDef{node("A"), node("B"), node("C"), node("D")}}
+	// The chain is more costly than the
+	// existing link, so it's unchanged
+	for _, l := range def.Links[:6] {
+		expect.Links = append(expect.Links, l)
+	}
+	if !reflect.DeepEqual(reduced, expect) {
+		t.Errorf("Expected reduced graph %+v; got %+v", expect, reduced)
+	}
+	reps := map[NodeID]NodeID{"s": "B", "t": "B", "p": "A", "q": "A"}
+	if !reflect.DeepEqual(r.Rep, reps) || r.Stubs != 2 || r.ChainNodes != 2 {
+		t.Errorf("Unexpected reduction: %+v", r)
+	}
+
+	g := NewGraph(reduced, MaxCost)
+	g.mergeClusters("A", "C")
+	expanded, err := r.Expand(g.GraphDef())
+	if err != nil {
+		t.Fatalf("Error expanding: %v", err)
+	}
+	clusters := map[NodeID]ClusterID{"A": "A", "B": "B", "C": "A", "D": "D", "p": "A", "q": "A", "s": "B", "t": "B"}
+	for _, n := range expanded.Nodes {
+		if n.Cluster != clusters[n.ID] {
+			t.Errorf("Expected %v in cluster %v; got %v", n.ID, clusters[n.ID], n.Cluster)
+		}
+	}
+	if !reflect.DeepEqual(expanded.Links, def.Canonical().Links) {
+		t.Errorf("Expected the original links; got %+v", expanded.Links)
+	}
+
+	// A cycle is kept, though stubs
+	// attached to it are folded
+	ring := GraphDef{
+		Nodes: []NodeDef{node("a"), node("b"), node("c"), node("x")},
+		Links: []LinkDef{{A: "a", B: "b", Cost: 1}, {A: "b", B: "c", Cost: 1}, {A: "c", B: "a", Cost: 1}, {A: "a", B: "x", Cost: 1}},
+	}
+	reduced, r, _ = Reduce(ring)
+	if len(reduced.Nodes) != 3 || len(reduced.Links) != 3 || r.Rep["x"] != "a" {
+		t.Errorf("Expected only the stub to be folded; got %+v", reduced)
+	}
+
+	// Nodes sharing a cluster with others
+	// may only be folded into that cluster
+	def.Nodes[0].Cluster = "A"
+	def.Nodes[7].Cluster = "A"
+	_, r, _ = Reduce(def)
+	if _, ok := r.Rep["t"]; ok {
+		t.Errorf("Expected t not to be folded into another cluster")
+	}
+
+	if _, _, err := Reduce(GraphDef{Links: []LinkDef{{A: "a", B: "b"}}}); err == nil {
+		t.Errorf("Expected error for link to undefined node")
+	}
+}
@@ -0,0 +1,204 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A MergeNode is a node in a graph's merge history. Leaves
+// are the clusters the graph had before its first round
+// of merging; every other node is the cluster formed
+// by merging its two children.
+type MergeNode struct {
+	Cluster ClusterID
+	// Round is the round in which the cluster
+	// was formed (counting from 1), or 0 for
+	// leaves.
+	Round int
+	// Cost is the cluster's cost at the end
+	// of the round in which it was formed
+	// (for leaves, before the first round).
+	Cost     int
+	Children []*MergeNode `json:",omitempty"`
+}
+
+// Record the leaves of the merge history,
+// unless this has already been done
+func (g *Graph) initHistory() {
+	if g.history != nil {
+		return
+	}
+	g.history = make(map[ClusterID]*MergeNode)
+	for cid := range g.clusters {
+		g.history[cid] = &MergeNode{Cluster: cid, Cost: g.cost(cid)}
+	}
+}
+
+// joinClusters merges c1 and c2, recording
+// the merge in g's history. All merges
+// decided by a round must go through it,
+// and once they have all been performed,
+// the round must call recordCosts.
+func (g *Graph) joinClusters(c1, c2 ClusterID) {
+	if c2 < c1 {
+		c1, c2 = c2, c1
+	}
+	a, b := g.history[c1], g.history[c2]
+	g.mergeClusters(c1, c2)
+	delete(g.history, c2)
+	g.history[c1] = &MergeNode{
+		Cluster:  c1,
+		Round:    g.rounds,
+		Children: []*MergeNode{a, b},
+	}
+}
+
+// Record the costs of the clusters formed in
+// the current round. A cluster's cost depends
+// on the rest of the graph, so it's computed
+// once all of the round's merges have been
+// performed, rather than after some arbitrary
+// subset of them.
+func (g *Graph) recordCosts() {
+	for cid, m := range g.history {
+		if m.Round == g.rounds && len(m.Children) > 0 {
+			m.Cost = g.cost(cid)
+		}
+	}
+}
+
+// MergeHistory returns the merge history of
+// each of g's current clusters, sorted by
+// cluster ID. If no rounds have been
+// performed, every tree is a single leaf.
+func (g *Graph) MergeHistory() []*MergeNode {
+	g.initHistory()
+	roots := make([]*MergeNode, 0, len(g.history))
+	for _, cid := range g.sortedClusterIDs() {
+		roots = append(roots, g.history[cid])
+	}
+	return roots
+}
+
+// Rounds returns the number of merge
+// rounds which have been performed on g.
+func (g *Graph) Rounds() int {
+	return g.rounds
+}
+
+// RestoreHistory replaces g's merge history with roots
+// (as returned by MergeHistory) and records that rounds
+// rounds have been performed, so that a graph rebuilt
+// from a saved definition can continue merging as if it
+// had never been saved. There must be exactly one root
+// for each of g's clusters.
+func (g *Graph) RestoreHistory(roots []*MergeNode, rounds int) error {
+	clusters := g.Clusters()
+	history := make(map[ClusterID]*MergeNode)
+	for _, r := range roots {
+		if _, ok := clusters[r.Cluster]; !ok {
+			return fmt.Errorf("merge history has root for nonexistent cluster %v", r.Cluster)
+		}
+		if _, ok := history[r.Cluster]; ok {
+			return fmt.Errorf("merge history has multiple roots for cluster %v", r.Cluster)
+		}
+		if r.Round > rounds {
+			return fmt.Errorf("cluster %v was formed in round %v, after round %v", r.Cluster, r.Round, rounds)
+		}
+		history[r.Cluster] = r
+	}
+	if len(history) != len(clusters) {
+		return fmt.Errorf("merge history has %v roots, but there are %v clusters", len(history), len(clusters))
+	}
+	g.history, g.rounds = history, rounds
+	return nil
+}
+
+// Leaves returns the IDs of the original
+// clusters which were merged to form m.
+func (m *MergeNode) Leaves() []ClusterID {
+	if len(m.Children) == 0 {
+		return []ClusterID{m.Cluster}
+	}
+	var leaves []ClusterID
+	for _, c := range m.Children {
+		leaves = append(leaves, c.Leaves()...)
+	}
+	return leaves
+}
+
+// Cut returns, for each original cluster in the
+// given merge history, the cluster it belonged
+// to at the end of the given round.
+func Cut(roots []*MergeNode, round int) map[ClusterID]ClusterID {
+	cut := make(map[ClusterID]ClusterID)
+	var visit func(m *MergeNode)
+	visit = func(m *MergeNode) {
+		if m.Round <= round {
+			for _, l := range m.Leaves() {
+				cut[l] = m.Cluster
+			}
+			return
+		}
+		for _, c := range m.Children {
+			visit(c)
+		}
+	}
+	for _, r := range roots {
+		visit(r)
+	}
+	return cut
+}
+
+// HistoryJSON returns the JSON encoding
+// of the given merge history.
+func HistoryJSON(roots []*MergeNode) ([]byte, error) {
+	return json.MarshalIndent(roots, "", "  ")
+}
+
+// Newick returns the given merge history in the
+// Newick tree format, one tree per line. Every
+// node is labeled with its cluster ID, and the
+// length of each branch is the number of rounds
+// between the child and its parent being formed.
+// Each root has a branch length of the number of
+// rounds between its formation and the last round.
+func Newick(roots []*MergeNode) string {
+	last := 0
+	for _, r := range roots {
+		if r.Round > last {
+			last = r.Round
+		}
+	}
+	var buf bytes.Buffer
+	var write func(m *MergeNode, parentRound int)
+	write = func(m *MergeNode, parentRound int) {
+		if len(m.Children) > 0 {
+			buf.WriteByte('(')
+			for i, c := range m.Children {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				write(c, m.Round)
+			}
+			buf.WriteByte(')')
+		}
+		fmt.Fprintf(&buf, "%v:%v", newickLabel(string(m.Cluster)), parentRound-m.Round)
+	}
+	for _, r := range roots {
+		write(r, last)
+		buf.WriteString(";\n")
+	}
+	return buf.String()
+}
+
+// Quote a label if it contains characters
+// which are special in Newick
+func newickLabel(s string) string {
+	if s != "" && !strings.ContainsAny(s, "()[]':;, \t\n_") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
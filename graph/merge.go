@@ -20,6 +20,9 @@ func (g *Graph) MergeRound(merge func(c, d ClusterID)) bool {
 	// the graph is considered to have stabilized)
 	changedOverall := false
 
+	g.initHistory()
+	g.rounds++
+
 	preferences := make(map[ClusterID][]ClusterID)
 
 	// Make copy of cluster list since
@@ -74,7 +77,7 @@ func (g *Graph) MergeRound(merge func(c, d ClusterID)) bool {
 						merge(c, p[0])
 					}
 
-					g.mergeClusters(c, p[0])
+					g.joinClusters(c, p[0])
 				}
 			}
 		}
@@ -96,6 +99,7 @@ func (g *Graph) MergeRound(merge func(c, d ClusterID)) bool {
 		}
 	}
 
+	g.recordCosts()
 	return changedOverall
 }
 
@@ -162,7 +166,6 @@ func (p preferenceList) Less(i, j int) bool {
 	default:
 		return clusterIDLess(p.c, p.list[i], p.c, p.list[j])
 	}
-	panic("Reached unreachable code")
 }
 
 // Return order in which c would prefer
@@ -0,0 +1,90 @@
+package graph
+
+import "container/heap"
+
+// Information about the shortest
+// path from a source to some node
+type pathInfo struct {
+	dist uint64
+	// The first hop on the path (nil
+	// for the source itself)
+	first *Node
+	// The node before this one on the
+	// path (nil for the source itself)
+	prev *Node
+}
+
+// shortestPaths computes the shortest paths from src to
+// every node reachable from it. For each node n, neighbors
+// must call visit once for every usable edge leaving n.
+func shortestPaths(src *Node, neighbors func(n *Node, visit func(dst *Node, cost uint64))) map[*Node]pathInfo {
+	paths := map[*Node]pathInfo{src: {}}
+	done := make(map[*Node]bool)
+	q := &pathQueue{{node: src}}
+	for q.Len() > 0 {
+		item := heap.Pop(q).(pathItem)
+		if done[item.node] {
+			// Stale entry
+			continue
+		}
+		done[item.node] = true
+		cur := paths[item.node]
+		neighbors(item.node, func(dst *Node, cost uint64) {
+			if done[dst] {
+				return
+			}
+			first := cur.first
+			if first == nil {
+				first = dst
+			}
+			dist := cur.dist + cost
+			if p, ok := paths[dst]; !ok || dist < p.dist || (dist == p.dist && first.id < p.first.id) {
+				paths[dst] = pathInfo{dist, first, item.node}
+				heap.Push(q, pathItem{dst, dist})
+			}
+		})
+	}
+	return paths
+}
+
+// A neighbors function (for use with shortestPaths)
+// which visits all of n's edges.
+func allEdges(n *Node, visit func(dst *Node, cost uint64)) {
+	for _, e := range n.edges {
+		visit(e.dst, e.cost)
+	}
+}
+
+// A neighbors function (for use with shortestPaths)
+// which visits those of n's edges whose destination
+// is in the same cluster as n.
+func clusterEdges(n *Node, visit func(dst *Node, cost uint64)) {
+	for _, e := range n.edges {
+		if e.dst.cluster == n.cluster {
+			visit(e.dst, e.cost)
+		}
+	}
+}
+
+type pathItem struct {
+	node *Node
+	dist uint64
+}
+
+type pathQueue []pathItem
+
+func (p pathQueue) Len() int { return len(p) }
+func (p pathQueue) Less(i, j int) bool {
+	if p[i].dist != p[j].dist {
+		return p[i].dist < p[j].dist
+	}
+	return p[i].node.id < p[j].node.id
+}
+func (p pathQueue) Swap(i, j int)       { p[i], p[j] = p[j], p[i] }
+func (p *pathQueue) Push(x interface{}) { *p = append(*p, x.(pathItem)) }
+func (p *pathQueue) Pop() interface{} {
+	old := *p
+	item := old[len(old)-1]
+	*p = old[:len(old)-1]
+	return item
+}
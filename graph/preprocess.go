@@ -0,0 +1,289 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// A Preprocessing describes the transformations
+// Preprocess applies to a graph definition, which
+// are applied in the order in which they're listed.
+type Preprocessing struct {
+	// Rename, if non-nil, renames nodes (such as to
+	// reverse an earlier relabeling). Nodes which
+	// are not in the map keep their IDs.
+	Rename map[NodeID]NodeID
+	// DropSelfLinks removes links from
+	// a node to itself.
+	DropSelfLinks bool
+	// DropDuplicates removes all but the last
+	// definition of each node and of each link
+	// (in either direction), as NewGraph would.
+	DropDuplicates bool
+	// LargestComponent removes every connected
+	// component except the one with the most
+	// nodes (ties are broken in favor of the
+	// component with the smallest node ID).
+	LargestComponent bool
+	// MinComponentSize removes every connected
+	// component with fewer nodes.
+	MinComponentSize int
+	// Relabel replaces node IDs with compact
+	// integers ("0", "1", ...), assigned in
+	// order of the original IDs.
+	Relabel bool
+}
+
+// A PreprocessReport describes what Preprocess removed.
+type PreprocessReport struct {
+	SelfLinks      int
+	DuplicateNodes int
+	DuplicateLinks int
+	// Components is the number of connected components
+	// before any were removed, and RemovedComponents the
+	// number removed, along with their nodes and links.
+	Components        int
+	RemovedComponents int
+	RemovedNodes      int
+	RemovedLinks      int
+	// Labels maps the new ID of each relabeled
+	// node to its original ID, or is nil if
+	// nodes weren't relabeled.
+	Labels map[NodeID]NodeID
+}
+
+// Preprocess returns a copy of def transformed as described
+// by p (def itself is not modified), along with a report of
+// what was removed. Renaming and relabeling a node whose
+// cluster has the same ID as the node (such as every node
+// in a graph which hasn't been clustered) renames the
+// cluster as well, along with the cluster of each of its
+// other members. It is an error for a renamed cluster to
+// take the ID of another cluster. Every link's endpoints
+// must be defined in def.Nodes.
+func Preprocess(def GraphDef, p Preprocessing) (GraphDef, PreprocessReport, error) {
+	var r PreprocessReport
+	nodes := make([]NodeDef, len(def.Nodes))
+	copy(nodes, def.Nodes)
+	links := make([]LinkDef, len(def.Links))
+	copy(links, def.Links)
+
+	defined := make(map[NodeID]bool)
+	for _, n := range nodes {
+		defined[n.ID] = true
+	}
+	for _, l := range links {
+		for _, id := range []NodeID{l.A, l.B} {
+			if !defined[id] {
+				return GraphDef{}, r, fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, id)
+			}
+		}
+	}
+
+	if p.Rename != nil {
+		var err error
+		nodes, links, err = rename(nodes, links, p.Rename)
+		if err != nil {
+			return GraphDef{}, r, err
+		}
+	}
+
+	if p.DropSelfLinks {
+		kept := links[:0]
+		for _, l := range links {
+			if l.A != l.B {
+				kept = append(kept, l)
+			}
+		}
+		r.SelfLinks = len(links) - len(kept)
+		links = kept
+	}
+
+	if p.DropDuplicates {
+		nodes, r.DuplicateNodes = lastNodes(nodes)
+		links, r.DuplicateLinks = lastLinks(links)
+	}
+
+	if p.LargestComponent || p.MinComponentSize > 0 {
+		nodes, links = filterComponents(nodes, links, p, &r)
+	}
+
+	if p.Relabel {
+		ids := make(nodeIDList, 0, len(nodes))
+		seen := make(map[NodeID]bool)
+		for _, n := range nodes {
+			if !seen[n.ID] {
+				seen[n.ID] = true
+				ids = append(ids, n.ID)
+			}
+		}
+		sort.Sort(ids)
+		m := make(map[NodeID]NodeID, len(ids))
+		r.Labels = make(map[NodeID]NodeID, len(ids))
+		for i, id := range ids {
+			label := NodeID(strconv.Itoa(i))
+			m[id] = label
+			r.Labels[label] = id
+		}
+		var err error
+		nodes, links, err = rename(nodes, links, m)
+		if err != nil {
+			return GraphDef{}, r, err
+		}
+	}
+	return GraphDef{Nodes: nodes, Links: links}, r, nil
+}
+
+// Rename nodes (and clusters with the same IDs
+// as their nodes) in place, as described by m
+func rename(nodes []NodeDef, links []LinkDef, m map[NodeID]NodeID) ([]NodeDef, []LinkDef, error) {
+	get := func(id NodeID) NodeID {
+		if to, ok := m[id]; ok {
+			return to
+		}
+		return id
+	}
+	// A cluster is renamed along with the node
+	// with the same ID, and every member of the
+	// cluster follows it
+	clusters := make(map[ClusterID]ClusterID)
+	for _, n := range nodes {
+		if n.Cluster == ClusterID(n.ID) {
+			clusters[n.Cluster] = ClusterID(get(n.ID))
+		}
+	}
+	getCluster := func(c ClusterID) ClusterID {
+		if to, ok := clusters[c]; ok {
+			return to
+		}
+		return c
+	}
+	// Two distinct nodes (or clusters) must
+	// not end up with the same ID
+	from := make(map[NodeID]NodeID)
+	fromCluster := make(map[ClusterID]ClusterID)
+	for i, n := range nodes {
+		id, c := get(n.ID), getCluster(n.Cluster)
+		if prev, ok := from[id]; ok && prev != n.ID {
+			return nil, nil, fmt.Errorf("nodes %v and %v would both be renamed %v", prev, n.ID, id)
+		}
+		if prev, ok := fromCluster[c]; ok && prev != n.Cluster {
+			return nil, nil, fmt.Errorf("clusters %v and %v would both be renamed %v", prev, n.Cluster, c)
+		}
+		from[id], fromCluster[c] = n.ID, n.Cluster
+		nodes[i].ID, nodes[i].Cluster = id, c
+	}
+	for i, l := range links {
+		links[i].A, links[i].B = get(l.A), get(l.B)
+	}
+	return nodes, links, nil
+}
+
+// Keep the last definition of each node,
+// returning the number of others removed
+func lastNodes(nodes []NodeDef) ([]NodeDef, int) {
+	last := make(map[NodeID]int)
+	for i, n := range nodes {
+		last[n.ID] = i
+	}
+	kept := make([]NodeDef, 0, len(last))
+	for i, n := range nodes {
+		if last[n.ID] == i {
+			kept = append(kept, n)
+		}
+	}
+	return kept, len(nodes) - len(kept)
+}
+
+// Keep the last definition of each link (in
+// either direction), returning the number of
+// others removed
+func lastLinks(links []LinkDef) ([]LinkDef, int) {
+	key := func(l LinkDef) [2]NodeID {
+		if l.B < l.A {
+			return [2]NodeID{l.B, l.A}
+		}
+		return [2]NodeID{l.A, l.B}
+	}
+	last := make(map[[2]NodeID]int)
+	for i, l := range links {
+		last[key(l)] = i
+	}
+	kept := make([]LinkDef, 0, len(last))
+	for i, l := range links {
+		if last[key(l)] == i {
+			kept = append(kept, l)
+		}
+	}
+	return kept, len(links) - len(kept)
+}
+
+// Remove the connected components which p
+// says to remove, recording them in r
+func filterComponents(nodes []NodeDef, links []LinkDef, p Preprocessing, r *PreprocessReport) ([]NodeDef, []LinkDef) {
+	// Union-find, with each component
+	// represented by its smallest node ID
+	parent := make(map[NodeID]NodeID)
+	find := func(id NodeID) NodeID {
+		root := id
+		for parent[root] != root {
+			root = parent[root]
+		}
+		for id != root {
+			next := parent[id]
+			parent[id] = root
+			id = next
+		}
+		return root
+	}
+	for _, n := range nodes {
+		parent[n.ID] = n.ID
+	}
+	for _, l := range links {
+		a, b := find(l.A), find(l.B)
+		if b < a {
+			a, b = b, a
+		}
+		parent[b] = a
+	}
+
+	size := make(map[NodeID]int)
+	for id := range parent {
+		size[find(id)]++
+	}
+	r.Components = len(size)
+	keep := make(map[NodeID]bool)
+	var largest NodeID
+	for root, n := range size {
+		if n < p.MinComponentSize {
+			continue
+		}
+		keep[root] = true
+		if len(keep) == 1 || n > size[largest] || (n == size[largest] && root < largest) {
+			largest = root
+		}
+	}
+	if p.LargestComponent && len(keep) > 0 {
+		keep = map[NodeID]bool{largest: true}
+	}
+	r.RemovedComponents = r.Components - len(keep)
+
+	keptNodes := nodes[:0]
+	for _, n := range nodes {
+		if keep[find(n.ID)] {
+			keptNodes = append(keptNodes, n)
+		} else {
+			r.RemovedNodes++
+		}
+	}
+	keptLinks := links[:0]
+	for _, l := range links {
+		if keep[find(l.A)] {
+			keptLinks = append(keptLinks, l)
+		} else {
+			r.RemovedLinks++
+		}
+	}
+	return keptNodes, keptLinks
+}
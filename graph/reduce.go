@@ -0,0 +1,284 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Reduction records how Reduce shrank a graph
+// definition, so that a clustering of the reduced
+// graph can be expanded to the original.
+type Reduction struct {
+	// Rep maps each removed node to the node
+	// of the reduced graph which represents it.
+	Rep map[NodeID]NodeID
+	// Stubs and ChainNodes are the numbers of
+	// stub nodes folded into their neighbors
+	// and of nodes removed from chains.
+	Stubs, ChainNodes int
+	original          GraphDef
+}
+
+// A node of the graph being reduced
+type reduceNode struct {
+	def NodeDef
+	// free is whether the node is alone in its
+	// cluster, so that it can be represented by
+	// a node in any cluster
+	free bool
+	adj  map[NodeID]*reduceLink
+}
+
+type reduceLink struct {
+	cost  uint64
+	attrs map[string]string
+}
+
+// Reduce shrinks def without changing the shortest-path
+// costs between the nodes which remain, by repeatedly:
+//
+//   - folding each stub (a node with a single neighbor)
+//     into its neighbor, which represents it
+//   - contracting each chain of degree-2 nodes between
+//     two distinct nodes into a single link whose cost is
+//     the cost of the chain (or, if the two are already
+//     linked, the lower of the two costs). Each node of
+//     the chain is represented by the nearer end (or, if
+//     they are equally near, the one with the smaller ID).
+//
+// A node is only removed if it is alone in its cluster or
+// is in the same cluster as its representative. Cycles of
+// degree-2 nodes with nothing else attached are kept, as
+// are self-links. Links created by contraction have no
+// attributes. The reduced definition is canonical.
+func Reduce(def GraphDef) (GraphDef, *Reduction, error) {
+	orig := def.Canonical()
+	r := &Reduction{Rep: make(map[NodeID]NodeID), original: orig}
+	nodes := make(map[NodeID]*reduceNode, len(orig.Nodes))
+	size := make(map[ClusterID]int)
+	for _, n := range orig.Nodes {
+		nodes[n.ID] = &reduceNode{def: n, adj: make(map[NodeID]*reduceLink)}
+		size[n.Cluster]++
+	}
+	for _, n := range nodes {
+		n.free = size[n.def.Cluster] == 1
+	}
+	var selfLinks []LinkDef
+	for _, l := range orig.Links {
+		a, ok := nodes[l.A]
+		if !ok {
+			return GraphDef{}, nil, fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, l.A)
+		}
+		b, ok := nodes[l.B]
+		if !ok {
+			return GraphDef{}, nil, fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, l.B)
+		}
+		if a == b {
+			selfLinks = append(selfLinks, l)
+			continue
+		}
+		rl := &reduceLink{cost: l.Cost, attrs: l.Attrs}
+		a.adj[l.B], b.adj[l.A] = rl, rl
+	}
+
+	for {
+		stubs := r.foldStubs(nodes)
+		chains := r.contractChains(nodes)
+		if !stubs && !chains {
+			break
+		}
+	}
+
+	// Representatives may themselves have been
+	// removed later; resolve them to survivors
+	for id := range r.Rep {
+		rep := r.Rep[id]
+		for {
+			next, ok := r.Rep[rep]
+			if !ok {
+				break
+			}
+			rep = next
+		}
+		r.Rep[id] = rep
+	}
+
+	reduced := GraphDef{
+		Nodes: make([]NodeDef, 0, len(nodes)),
+		Links: make([]LinkDef, 0),
+	}
+	for _, n := range orig.Nodes {
+		if _, ok := nodes[n.ID]; ok {
+			reduced.Nodes = append(reduced.Nodes, n)
+		}
+	}
+	for _, n := range nodes {
+		for id, l := range n.adj {
+			if n.def.ID < id {
+				reduced.Links = append(reduced.Links, LinkDef{A: n.def.ID, B: id, Cost: l.cost, Attrs: l.attrs})
+			}
+		}
+	}
+	for _, l := range selfLinks {
+		if _, ok := nodes[l.A]; ok {
+			reduced.Links = append(reduced.Links, l)
+		}
+	}
+	return reduced.Canonical(), r, nil
+}
+
+// Fold stubs into their neighbors until none
+// remain, returning whether any were folded
+func (r *Reduction) foldStubs(nodes map[NodeID]*reduceNode) bool {
+	var queue []NodeID
+	for id, n := range nodes {
+		if len(n.adj) == 1 {
+			queue = append(queue, id)
+		}
+	}
+	sort.Sort(nodeIDList(queue))
+	folded := false
+	for len(queue) > 0 {
+		n, ok := nodes[queue[0]]
+		queue = queue[1:]
+		if !ok || len(n.adj) != 1 {
+			continue
+		}
+		var to *reduceNode
+		for id := range n.adj {
+			to = nodes[id]
+		}
+		if !n.free && n.def.Cluster != to.def.Cluster {
+			continue
+		}
+		delete(to.adj, n.def.ID)
+		delete(nodes, n.def.ID)
+		r.Rep[n.def.ID] = to.def.ID
+		r.Stubs++
+		folded = true
+		if len(to.adj) == 1 {
+			queue = append(queue, to.def.ID)
+		}
+	}
+	return folded
+}
+
+// Contract every chain of free degree-2 nodes
+// between two distinct nodes, returning whether
+// any were contracted
+func (r *Reduction) contractChains(nodes map[NodeID]*reduceNode) bool {
+	inner := func(n *reduceNode) bool { return n.free && len(n.adj) == 2 }
+	ids := make(nodeIDList, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	contracted := false
+	for _, id := range ids {
+		n, ok := nodes[id]
+		if !ok || !inner(n) {
+			continue
+		}
+		// Walk from n in both directions to
+		// the ends of the chain, collecting
+		// the chain's nodes in order
+		var ends [2]*reduceNode
+		var sides [2][]*reduceNode
+		var costs [2][]uint64
+		cycle := false
+		i := 0
+		for next, l := range n.adj {
+			prev, cur := n, nodes[next]
+			costs[i] = append(costs[i], l.cost)
+			for inner(cur) && cur != n {
+				sides[i] = append(sides[i], cur)
+				for id, l := range cur.adj {
+					if id != prev.def.ID {
+						prev, cur = cur, nodes[id]
+						costs[i] = append(costs[i], l.cost)
+						break
+					}
+				}
+			}
+			cycle = cycle || cur == n
+			ends[i] = cur
+			i++
+		}
+		if cycle || ends[0] == ends[1] {
+			continue
+		}
+
+		// The chain from ends[0] to ends[1],
+		// and the cost of each of its links
+		var chain []*reduceNode
+		var linkCosts []uint64
+		for j := len(sides[0]) - 1; j >= 0; j-- {
+			chain = append(chain, sides[0][j])
+		}
+		chain = append(chain, n)
+		chain = append(chain, sides[1]...)
+		for j := len(costs[0]) - 1; j >= 0; j-- {
+			linkCosts = append(linkCosts, costs[0][j])
+		}
+		linkCosts = append(linkCosts, costs[1]...)
+		var total uint64
+		for _, c := range linkCosts {
+			total += c
+		}
+
+		u, w := ends[0], ends[1]
+		var dist uint64
+		for j, c := range chain {
+			dist += linkCosts[j]
+			rep := u
+			if total-dist < dist || (total-dist == dist && w.def.ID < u.def.ID) {
+				rep = w
+			}
+			r.Rep[c.def.ID] = rep.def.ID
+			delete(nodes, c.def.ID)
+			r.ChainNodes++
+		}
+		delete(u.adj, chain[0].def.ID)
+		delete(w.adj, chain[len(chain)-1].def.ID)
+		if l, ok := u.adj[w.def.ID]; ok {
+			if total < l.cost {
+				l.cost = total
+			}
+		} else {
+			l := &reduceLink{cost: total}
+			u.adj[w.def.ID], w.adj[u.def.ID] = l, l
+		}
+		contracted = true
+	}
+	return contracted
+}
+
+// Expand returns the original definition with each node
+// placed in the cluster which it, or its representative,
+// has in reduced (such as a clustering of the reduced
+// graph computed by merging). Every node of the reduced
+// graph must be defined in reduced.
+func (r *Reduction) Expand(reduced GraphDef) (GraphDef, error) {
+	clusters := make(map[NodeID]ClusterID, len(reduced.Nodes))
+	for _, n := range reduced.Nodes {
+		clusters[n.ID] = n.Cluster
+	}
+	def := GraphDef{
+		Nodes: make([]NodeDef, len(r.original.Nodes)),
+		Links: r.original.Links,
+	}
+	for i, n := range r.original.Nodes {
+		rep, ok := r.Rep[n.ID]
+		if !ok {
+			rep = n.ID
+		}
+		c, ok := clusters[rep]
+		if !ok {
+			return GraphDef{}, fmt.Errorf("node %v is not defined in the reduced graph", rep)
+		}
+		n.Cluster = c
+		def.Nodes[i] = n
+	}
+	return def, nil
+}
@@ -0,0 +1,154 @@
+package graph
+
+// A Route describes how a node forwards
+// traffic toward a destination.
+type Route struct {
+	NextHop NodeID
+	// Exit is the border node through which
+	// traffic leaves the node's cluster. It is
+	// empty for intra-cluster routes.
+	Exit NodeID
+	Cost uint64
+}
+
+// A RoutingTable holds the routes that a node
+// installs given its graph's clustering.
+type RoutingTable struct {
+	Node NodeID
+	// Intra holds a route to every other node
+	// in the same cluster which is reachable
+	// without leaving the cluster.
+	Intra map[NodeID]Route
+	// Inter holds a single, summarized route
+	// to every other reachable cluster.
+	Inter map[ClusterID]Route
+}
+
+// Size returns the number of entries in r.
+func (r RoutingTable) Size() int {
+	return len(r.Intra) + len(r.Inter)
+}
+
+// RoutingTable computes the routing table of the node
+// with the given node ID, or returns an empty table if
+// no such node exists.
+//
+// Intra-cluster routes follow the shortest path within
+// the cluster. The summarized route to a remote cluster
+// follows the shortest path to the nearest of its nodes
+// (which is necessarily one of its border nodes).
+func (g *Graph) RoutingTable(n NodeID) RoutingTable {
+	r := RoutingTable{
+		Node:  n,
+		Intra: make(map[NodeID]Route),
+		Inter: make(map[ClusterID]Route),
+	}
+	src := g.Node(n)
+	if src == nil {
+		return r
+	}
+
+	for dst, p := range shortestPaths(src, clusterEdges) {
+		if dst != src {
+			r.Intra[dst.id] = Route{NextHop: p.first.id, Cost: p.dist}
+		}
+	}
+
+	// The nearest node of each remote cluster
+	nearest := make(map[*Cluster]*Node)
+	paths := shortestPaths(src, allEdges)
+	for dst, p := range paths {
+		if dst.cluster == src.cluster {
+			continue
+		}
+		m, ok := nearest[dst.cluster]
+		if !ok || p.dist < paths[m].dist || (p.dist == paths[m].dist && dst.id < m.id) {
+			nearest[dst.cluster] = dst
+		}
+	}
+	for c, dst := range nearest {
+		p := paths[dst]
+		r.Inter[c.id] = Route{
+			NextHop: p.first.id,
+			Exit:    exitNode(src, dst, paths).id,
+			Cost:    p.dist,
+		}
+	}
+	return r
+}
+
+// FIBSizes returns the number of routing table
+// entries installed by each node in g.
+func (g *Graph) FIBSizes() map[NodeID]int {
+	sizes := make(map[NodeID]int)
+	for _, n := range g.nodes {
+		sizes[n.id] = g.RoutingTable(n.id).Size()
+	}
+	return sizes
+}
+
+// FlatFIBSize returns the total number of routing
+// table entries which would be installed by all
+// nodes in g if g were not clustered (that is, if
+// every node had a route to every other node
+// reachable from it).
+func (g *Graph) FlatFIBSize() int {
+	total := 0
+	for _, c := range g.components() {
+		total += c.nodes * (c.nodes - 1)
+	}
+	return total
+}
+
+type component struct {
+	nodes, links int
+}
+
+// Return the number of nodes and links
+// in each of g's connected components
+func (g *Graph) components() []component {
+	var comps []component
+	seen := make(map[*Node]bool)
+	for _, n := range g.nodes {
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		var c component
+		stack := []*Node{n}
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			c.nodes++
+			c.links += cur.edges.Len()
+			for _, e := range cur.edges {
+				if !seen[e.dst] {
+					seen[e.dst] = true
+					stack = append(stack, e.dst)
+				}
+			}
+		}
+		// Each link was counted from both ends
+		c.links /= 2
+		comps = append(comps, c)
+	}
+	return comps
+}
+
+// Return the last node on the shortest path from
+// src to dst which is in the same cluster as src
+// and precedes the path's first departure from
+// that cluster.
+func exitNode(src, dst *Node, paths map[*Node]pathInfo) *Node {
+	// Walk backwards, remembering the most
+	// recent (that is, earliest on the path)
+	// departure from src's cluster.
+	exit := src
+	for cur := dst; cur != src; cur = paths[cur].prev {
+		prev := paths[cur].prev
+		if prev.cluster == src.cluster && cur.cluster != src.cluster {
+			exit = prev
+		}
+	}
+	return exit
+}
@@ -0,0 +1,305 @@
+// Package sample shrinks graphs which are too large to
+// simulate comfortably by choosing a subset of their
+// nodes.
+//
+// Every method returns the subgraph induced by the chosen
+// nodes: all of the links between them, with the nodes'
+// clusters and attributes unchanged. Given the same graph
+// (in any order) and the same arguments, a method always
+// returns the same sample, in canonical form.
+//
+// The methods differ in how well they preserve the shape
+// of the original graph. Traversals (BFS, Snowball and
+// ForestFire) keep neighborhoods intact, but over-sample
+// high-degree nodes near the start. Random walks with
+// restarts and forest fires come closest to preserving
+// the degree distribution. Induced random node sampling
+// is unbiased in which nodes it chooses, but loses most
+// links unless the sample is large, so it shrinks every
+// node's degree.
+package sample
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/synful/cluster-simulate/graph"
+)
+
+// A Config holds the settings shared by all methods.
+type Config struct {
+	// Seed seeds the random number generator.
+	Seed int64
+	// Start is the node from which traversals and
+	// walks begin. If it is empty, they begin at a
+	// random node. Whenever a traversal or walk
+	// runs out of nodes to visit before the sample
+	// is complete, it continues from a random node
+	// which hasn't been chosen.
+	Start graph.NodeID
+}
+
+// The number of steps a random walk may take without
+// finding a new node before it gives up and jumps to
+// a random node
+const stuckSteps = 1000
+
+// BFS samples n nodes of def by breadth-first search.
+// If def has n or fewer nodes, all of them are returned.
+func BFS(def graph.GraphDef, n int, cfg Config) (graph.GraphDef, error) {
+	return Snowball(def, n, 0, cfg)
+}
+
+// Snowball samples n nodes of def by snowball sampling:
+// a breadth-first search which, from each node, visits
+// at most k of its neighbors which haven't been chosen,
+// chosen at random. If k is 0, every neighbor is visited
+// (as in BFS). If def has n or fewer nodes, all of them
+// are returned.
+func Snowball(def graph.GraphDef, n, k int, cfg Config) (graph.GraphDef, error) {
+	if k < 0 {
+		return graph.GraphDef{}, fmt.Errorf("number of neighbors must be non-negative")
+	}
+	s, err := newSampler(def, n, cfg)
+	if err != nil {
+		return graph.GraphDef{}, err
+	}
+	for !s.done() {
+		queue := []int{s.next()}
+		for len(queue) > 0 && !s.done() {
+			u := queue[0]
+			queue = queue[1:]
+			for _, v := range s.unchosenNeighbors(u, k) {
+				if s.done() {
+					break
+				}
+				s.add(v)
+				queue = append(queue, v)
+			}
+		}
+	}
+	return s.result(), nil
+}
+
+// RandomWalk samples n nodes of def by a random walk
+// which, at each step, returns to the node it began
+// at with probability restart, and otherwise moves to
+// a random neighbor. If def has n or fewer nodes, all
+// of them are returned.
+func RandomWalk(def graph.GraphDef, n int, restart float64, cfg Config) (graph.GraphDef, error) {
+	if restart < 0 || restart >= 1 {
+		return graph.GraphDef{}, fmt.Errorf("restart probability must be in [0, 1)")
+	}
+	s, err := newSampler(def, n, cfg)
+	if err != nil {
+		return graph.GraphDef{}, err
+	}
+	start, cur, stuck := -1, -1, 0
+	for !s.done() {
+		if cur < 0 || len(s.adj[cur]) == 0 || stuck >= stuckSteps {
+			start = s.next()
+			cur, stuck = start, 0
+			continue
+		}
+		if s.r.Float64() < restart {
+			cur = start
+		} else {
+			cur = s.adj[cur][s.r.Intn(len(s.adj[cur]))]
+		}
+		if s.add(cur) {
+			stuck = 0
+		} else {
+			stuck++
+		}
+	}
+	return s.result(), nil
+}
+
+// ForestFire samples n nodes of def by forest fire
+// sampling: from each burning node, the fire spreads
+// to a random number of neighbors which haven't been
+// chosen, geometrically distributed with mean p/(1-p),
+// where p is the forward burning probability. If the
+// fire dies out, a new one is started. If def has n
+// or fewer nodes, all of them are returned.
+func ForestFire(def graph.GraphDef, n int, p float64, cfg Config) (graph.GraphDef, error) {
+	if p < 0 || p >= 1 {
+		return graph.GraphDef{}, fmt.Errorf("burning probability must be in [0, 1)")
+	}
+	s, err := newSampler(def, n, cfg)
+	if err != nil {
+		return graph.GraphDef{}, err
+	}
+	for !s.done() {
+		queue := []int{s.next()}
+		for len(queue) > 0 && !s.done() {
+			u := queue[0]
+			queue = queue[1:]
+			burn := 0
+			for s.r.Float64() < p {
+				burn++
+			}
+			if burn == 0 {
+				continue
+			}
+			for _, v := range s.unchosenNeighbors(u, burn) {
+				if s.done() {
+					break
+				}
+				s.add(v)
+				queue = append(queue, v)
+			}
+		}
+	}
+	return s.result(), nil
+}
+
+// RandomNode samples n nodes of def uniformly at
+// random. cfg.Start is ignored. If def has n or
+// fewer nodes, all of them are returned.
+func RandomNode(def graph.GraphDef, n int, cfg Config) (graph.GraphDef, error) {
+	s, err := newSampler(def, n, cfg)
+	if err != nil {
+		return graph.GraphDef{}, err
+	}
+	for _, i := range s.perm {
+		if s.done() {
+			break
+		}
+		s.add(i)
+	}
+	return s.result(), nil
+}
+
+/*
+	SAMPLER
+*/
+
+// A sampler tracks the nodes chosen so far
+type sampler struct {
+	def    graph.GraphDef
+	adj    [][]int
+	r      *rand.Rand
+	n      int
+	start  int // -1 if not given
+	chosen []bool
+	count  int
+	// A random order of the nodes, and the
+	// position in it from which next looks
+	// for a node which hasn't been chosen
+	perm []int
+	pos  int
+}
+
+func newSampler(def graph.GraphDef, n int, cfg Config) (*sampler, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("sample size must be positive")
+	}
+	s := &sampler{def: def.Canonical(), n: n, start: -1}
+	index := make(map[graph.NodeID]int, len(s.def.Nodes))
+	for i, nd := range s.def.Nodes {
+		index[nd.ID] = i
+	}
+	s.adj = make([][]int, len(s.def.Nodes))
+	for _, l := range s.def.Links {
+		a, ok := index[l.A]
+		if !ok {
+			return nil, fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, l.A)
+		}
+		b, ok := index[l.B]
+		if !ok {
+			return nil, fmt.Errorf("link %v-%v: no such node: %v", l.A, l.B, l.B)
+		}
+		if a != b {
+			s.adj[a] = append(s.adj[a], b)
+			s.adj[b] = append(s.adj[b], a)
+		}
+	}
+	if cfg.Start != "" {
+		i, ok := index[cfg.Start]
+		if !ok {
+			return nil, fmt.Errorf("no such start node: %v", cfg.Start)
+		}
+		s.start = i
+	}
+
+	s.r = rand.New(rand.NewSource(cfg.Seed))
+	s.perm = s.r.Perm(len(s.def.Nodes))
+	s.chosen = make([]bool, len(s.def.Nodes))
+	if n > len(s.def.Nodes) {
+		s.n = len(s.def.Nodes)
+	}
+	return s, nil
+}
+
+// Choose node i, returning whether
+// it hadn't already been chosen
+func (s *sampler) add(i int) bool {
+	if s.chosen[i] {
+		return false
+	}
+	s.chosen[i] = true
+	s.count++
+	return true
+}
+
+func (s *sampler) done() bool { return s.count >= s.n }
+
+// Choose and return the start node, or if it
+// has already been chosen, a random node which
+// hasn't. It must not be called once every
+// node has been chosen.
+func (s *sampler) next() int {
+	if s.start >= 0 && !s.chosen[s.start] {
+		s.add(s.start)
+		return s.start
+	}
+	for s.chosen[s.perm[s.pos]] {
+		s.pos++
+	}
+	s.add(s.perm[s.pos])
+	return s.perm[s.pos]
+}
+
+// Return at most k (or, if k is 0, all) of
+// the neighbors of u which haven't been
+// chosen, in random order
+func (s *sampler) unchosenNeighbors(u, k int) []int {
+	var nbrs []int
+	for _, v := range s.adj[u] {
+		if !s.chosen[v] {
+			nbrs = append(nbrs, v)
+		}
+	}
+	for i := len(nbrs) - 1; i > 0; i-- {
+		j := s.r.Intn(i + 1)
+		nbrs[i], nbrs[j] = nbrs[j], nbrs[i]
+	}
+	if k > 0 && len(nbrs) > k {
+		nbrs = nbrs[:k]
+	}
+	return nbrs
+}
+
+// Return the subgraph induced by
+// the chosen nodes (in canonical
+// form, since def is canonical)
+func (s *sampler) result() graph.GraphDef {
+	sample := graph.GraphDef{
+		Nodes: make([]graph.NodeDef, 0, s.count),
+		Links: make([]graph.LinkDef, 0),
+	}
+	index := make(map[graph.NodeID]int, len(s.def.Nodes))
+	for i, nd := range s.def.Nodes {
+		index[nd.ID] = i
+		if s.chosen[i] {
+			sample.Nodes = append(sample.Nodes, nd)
+		}
+	}
+	for _, l := range s.def.Links {
+		if s.chosen[index[l.A]] && s.chosen[index[l.B]] {
+			sample.Links = append(sample.Links, l)
+		}
+	}
+	return sample
+}
@@ -0,0 +1,160 @@
+package sample
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/generate"
+)
+
+func TestMethods(t *testing.T) {
+	def, err := generate.BarabasiAlbert(200, 2, generate.Config{Seed: 1})
+	if err != nil {
+		t.Fatalf("Error generating graph: %v", err)
+	}
+	links := make(map[[2]NodeID]bool)
+	for _, l := range def.Canonical().Links {
+		links[[2]NodeID{l.A, l.B}] = true
+	}
+
+	for _, test := range []struct {
+		name   string
+		sample func(def GraphDef, n int, cfg Config) (GraphDef, error)
+		// Whether the sample of a connected graph is
+		// connected (traversals that don't die out)
+		connected bool
+	}{
+		{"bfs", BFS, true},
+		{"snowball", func(def GraphDef, n int, cfg Config) (GraphDef, error) { return Snowball(def, n, 2, cfg) }, true},
+		{"walk", func(def GraphDef, n int, cfg Config) (GraphDef, error) { return RandomWalk(def, n, 0.15, cfg) }, true},
+		{"forest-fire", func(def GraphDef, n int, cfg Config) (GraphDef, error) { return ForestFire(def, n, 0.7, cfg) }, false},
+		{"node", RandomNode, false},
+	} {
+		s, err := test.sample(def, 50, Config{Seed: 3})
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(s.Nodes) != 50 {
+			t.Errorf("%v: expected 50 nodes; got %v", test.name, len(s.Nodes))
+		}
+		// The sample must be the induced subgraph
+		chosen := make(map[NodeID]bool)
+		for _, n := range s.Nodes {
+			chosen[n.ID] = true
+		}
+		induced := 0
+		for l := range links {
+			if chosen[l[0]] && chosen[l[1]] {
+				induced++
+			}
+		}
+		for _, l := range s.Links {
+			if !links[[2]NodeID{l.A, l.B}] {
+				t.Errorf("%v: unexpected link %v-%v", test.name, l.A, l.B)
+			}
+		}
+		if len(s.Links) != induced {
+			t.Errorf("%v: expected %v links; got %v", test.name, induced, len(s.Links))
+		}
+		if test.connected {
+			if d := distances(s, s.Nodes[0].ID); len(d) != len(s.Nodes) {
+				t.Errorf("%v: expected the sample to be connected; %v of %v nodes are reachable", test.name, len(d), len(s.Nodes))
+			}
+		}
+
+		// The same seed must give the same sample,
+		// however the definition is ordered
+		shuffled := GraphDef{Nodes: make([]NodeDef, len(def.Nodes)), Links: def.Links}
+		for i, n := range def.Nodes {
+			shuffled.Nodes[len(def.Nodes)-1-i] = n
+		}
+		if s2, _ := test.sample(shuffled, 50, Config{Seed: 3}); !reflect.DeepEqual(s, s2) {
+			t.Errorf("%v: expected the same seed to produce the same sample", test.name)
+		}
+
+		if s, _ := test.sample(def, 500, Config{}); !reflect.DeepEqual(s, def.Canonical()) {
+			t.Errorf("%v: expected the whole graph when the sample is larger", test.name)
+		}
+	}
+
+	// A BFS sample from the start node contains
+	// its whole neighborhood, and, beyond that,
+	// every node closer to the start than the
+	// farthest node chosen
+	dist := distances(def, "n7")
+	for _, n := range []int{1, 1 + degree(def, "n7"), 50} {
+		s, _ := BFS(def, n, Config{Start: "n7"})
+		chosen := make(map[NodeID]bool)
+		farthest := 0
+		for _, nd := range s.Nodes {
+			chosen[nd.ID] = true
+			if dist[nd.ID] > farthest {
+				farthest = dist[nd.ID]
+			}
+		}
+		if !chosen["n7"] {
+			t.Errorf("Expected the sample to include the start node; got %+v", s.Nodes)
+		}
+		for id, d := range dist {
+			if (d < farthest || (d == 1 && n > degree(def, "n7"))) && !chosen[id] {
+				t.Errorf("Expected a sample of %v nodes reaching distance %v to include %v (at distance %v)", n, farthest, id, d)
+			}
+		}
+	}
+	if _, err := BFS(def, 3, Config{Start: "nope"}); err == nil {
+		t.Errorf("Expected error for unknown start node")
+	}
+	if _, err := RandomNode(def, 0, Config{}); err == nil {
+		t.Errorf("Expected error for empty sample")
+	}
+	if _, err := ForestFire(def, 3, 1, Config{}); err == nil {
+		t.Errorf("Expected error for burning probability of 1")
+	}
+}
+
+func TestDisconnected(t *testing.T) {
+	// Traversals must continue into other
+	// components once one is exhausted
+	def, _ := generate.ErdosRenyi(20, 0, generate.Config{})
+	for _, s := range []func() (GraphDef, error){
+		func() (GraphDef, error) { return BFS(def, 10, Config{}) },
+		func() (GraphDef, error) { return RandomWalk(def, 10, 0.15, Config{}) },
+		func() (GraphDef, error) { return ForestFire(def, 10, 0.5, Config{}) },
+	} {
+		if s, err := s(); err != nil || len(s.Nodes) != 10 {
+			t.Errorf("Expected 10 nodes; got %v (error: %v)", len(s.Nodes), err)
+		}
+	}
+}
+
+// Return the distance from start to every
+// node reachable from it
+func distances(def GraphDef, start NodeID) map[NodeID]int {
+	adj := make(map[NodeID][]NodeID)
+	for _, l := range def.Links {
+		adj[l.A] = append(adj[l.A], l.B)
+		adj[l.B] = append(adj[l.B], l.A)
+	}
+	dist := map[NodeID]int{start: 0}
+	for queue := []NodeID{start}; len(queue) > 0; queue = queue[1:] {
+		for _, v := range adj[queue[0]] {
+			if _, ok := dist[v]; !ok {
+				dist[v] = dist[queue[0]] + 1
+				queue = append(queue, v)
+			}
+		}
+	}
+	return dist
+}
+
+func degree(def GraphDef, id NodeID) int {
+	d := 0
+	for _, l := range def.Links {
+		if l.A == id || l.B == id {
+			d++
+		}
+	}
+	return d
+}
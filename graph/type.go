@@ -1,6 +1,9 @@
 package graph
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 type NodeID string
 
@@ -190,6 +193,31 @@ func (e edgeMap) Equal(f edgeMap) bool {
 }
 
 type edge struct {
-	cost uint64
-	dst  *Node
+	cost  uint64
+	dst   *Node
+	attrs map[string]string
+}
+
+type nodeIDList []NodeID
+
+func (n nodeIDList) Len() int           { return len(n) }
+func (n nodeIDList) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nodeIDList) Less(i, j int) bool { return n[i] < n[j] }
+
+func sortedNodeIDs(nodes map[NodeID]*Node) []NodeID {
+	ids := make(nodeIDList, 0, len(nodes))
+	for nid := range nodes {
+		ids = append(ids, nid)
+	}
+	sort.Sort(ids)
+	return ids
+}
+
+func (n *Node) sortedNeighbors() []NodeID {
+	ids := make(nodeIDList, 0, len(n.edges))
+	for nid := range n.edges {
+		ids = append(ids, nid)
+	}
+	sort.Sort(ids)
+	return ids
 }
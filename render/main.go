@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/encoding"
+)
+
+const (
+	ERR_USAGE = 2 + iota
+	ERR_IO
+	ERR_PARSE
+)
+
+var (
+	graphFilename  = flag.String("graph", "", "a file containing the clustered graph to render (such as a round file written by simulate)")
+	outputFilename = flag.String("output", "-", "the destination to write the DOT graph")
+	collapse       = flag.Bool("collapse", false, "draw each cluster as a single node")
+)
+
+func main() {
+	flag.Parse()
+	encoding.Warnings = os.Stderr
+
+	if *graphFilename == "" {
+		fmt.Fprintf(os.Stderr, "No graph file specified\n")
+		os.Exit(ERR_USAGE)
+	}
+
+	f, err := os.Open(*graphFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening graph file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+
+	d, err := encoding.NewFormatDecoder("", f, *graphFilename, nil)
+	var g *graph.Graph
+	if err == nil {
+		g, err = encoding.ReadGraph(d, graph.MaxCost)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing graph file: %v\n", err)
+		os.Exit(ERR_PARSE)
+	}
+	f.Close()
+
+	output := os.Stdout
+	if *outputFilename != "-" {
+		output, err = os.Create(*outputFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
+			os.Exit(ERR_IO)
+		}
+	}
+
+	// Name the graph after the file (e.g. "0003"
+	// for the round file "0003.def")
+	name := filepath.Base(*graphFilename)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	err = g.WriteDOT(output, graph.DOTOptions{Name: name, Collapse: *collapse})
+	if err == nil {
+		err = output.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+}
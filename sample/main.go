@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/encoding"
+	"github.com/synful/cluster-simulate/graph/sample"
+)
+
+const (
+	ERR_USAGE = 2 + iota
+	ERR_IO
+	ERR_PARSE
+)
+
+var methods = []string{"bfs", "snowball", "walk", "forest-fire", "node"}
+
+var (
+	graphFilename  = flag.String("graph", "", "a file containing the graph to sample")
+	graphFormat    = flag.String("format", "", "the format of the graph file: "+strings.Join(encoding.ReadableFormats(), ", ")+" (detected from its content or extension if empty)")
+	outputFilename = flag.String("output", "-", "the destination to write the sampled graph")
+	outputFormat   = flag.String("outputFormat", "", "the format of the output file: "+strings.Join(encoding.WritableFormats(), ", ")+"; if empty, it is inferred from the output file's extension, defaulting to json")
+	compress       = flag.String("compress", "none", "the compression of the output file: "+strings.Join(encoding.Compressions, " or "))
+	method         = flag.String("method", "walk", "the sampling method: "+strings.Join(methods, ", "))
+	size           = flag.Int("n", 0, "the number of nodes to sample")
+	fraction       = flag.Float64("fraction", 0, "the fraction of the graph's nodes to sample (instead of -n)")
+	seed           = flag.Int64("seed", 0, "the seed of the random number generator")
+	start          = flag.String("start", "", "the node from which traversals and walks begin (random if empty)")
+
+	// Method parameters
+	fanout  = flag.Int("fanout", 3, "the number of neighbors of each node to visit, or 0 for all (snowball)")
+	restart = flag.Float64("restart", 0.15, "the probability of returning to the start at each step (walk)")
+	burn    = flag.Float64("burn", 0.7, "the forward burning probability (forest-fire)")
+)
+
+func main() {
+	flag.Parse()
+	encoding.Warnings = os.Stderr
+
+	if *graphFilename == "" {
+		fmt.Fprintf(os.Stderr, "No graph file specified\n")
+		os.Exit(ERR_USAGE)
+	}
+	if (*size > 0) == (*fraction > 0) {
+		fmt.Fprintf(os.Stderr, "Exactly one of -n and -fraction must be given\n")
+		os.Exit(ERR_USAGE)
+	}
+	if *fraction > 1 {
+		fmt.Fprintf(os.Stderr, "Fraction must be at most 1\n")
+		os.Exit(ERR_USAGE)
+	}
+	if *outputFormat == "" {
+		*outputFormat = "json"
+		if f, ok := encoding.ByExtension(*outputFilename); ok && f.NewEncoder != nil {
+			*outputFormat = f.Name
+		}
+	}
+	if _, err := encoding.NewFormatEncoder(*outputFormat, ioutil.Discard, encoding.Header{}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	if _, err := encoding.NewCompressor(ioutil.Discard, *compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+
+	f, err := os.Open(*graphFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening graph file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+	d, err := encoding.NewFormatDecoder(*graphFormat, f, *graphFilename, nil)
+	var def graph.GraphDef
+	if err == nil {
+		def, err = encoding.Decode(d)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing graph file: %v\n", err)
+		os.Exit(ERR_PARSE)
+	}
+	f.Close()
+
+	n := *size
+	if *fraction > 0 {
+		n = int(*fraction*float64(len(def.Nodes)) + 0.5)
+		if n < 1 {
+			n = 1
+		}
+	}
+
+	// The parameters used, recorded in the header
+	params := map[string]interface{}{}
+	cfg := sample.Config{Seed: *seed, Start: graph.NodeID(*start)}
+	var s graph.GraphDef
+	switch *method {
+	case "bfs":
+		s, err = sample.BFS(def, n, cfg)
+	case "snowball":
+		params["fanout"] = *fanout
+		s, err = sample.Snowball(def, n, *fanout, cfg)
+	case "walk":
+		params["restart"] = *restart
+		s, err = sample.RandomWalk(def, n, *restart, cfg)
+	case "forest-fire":
+		params["burn"] = *burn
+		s, err = sample.ForestFire(def, n, *burn, cfg)
+	case "node":
+		s, err = sample.RandomNode(def, n, cfg)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown method: %v\n", *method)
+		os.Exit(ERR_USAGE)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error sampling graph: %v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+
+	output := os.Stdout
+	if *outputFilename != "-" {
+		output, err = os.Create(*outputFilename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening output file: %v\n", err)
+			os.Exit(ERR_IO)
+		}
+	}
+
+	h := encoding.Header{
+		Generator: "sample",
+		Meta: map[string]string{
+			"method":            *method,
+			"seed":              fmt.Sprint(*seed),
+			"n":                 fmt.Sprint(n),
+			"source":            *graphFilename,
+			"sourceFingerprint": encoding.Fingerprint(def),
+		},
+	}
+	if *start != "" {
+		h.Meta["start"] = *start
+	}
+	for name, v := range params {
+		h.Meta[name] = fmt.Sprint(v)
+	}
+	w, _ := encoding.NewCompressor(output, *compress)
+	enc, _ := encoding.NewFormatEncoder(*outputFormat, w, h)
+	err = encoding.Encode(enc, s)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+
+	orig := def.Canonical()
+	fmt.Fprintf(os.Stderr, "Sampled %v of %v nodes and %v of %v links\n", len(s.Nodes), len(orig.Nodes), len(s.Links), len(orig.Links))
+	fmt.Fprintf(os.Stderr, "Mean degree %.2f (originally %.2f)\n", meanDegree(s), meanDegree(orig))
+}
+
+func meanDegree(def graph.GraphDef) float64 {
+	if len(def.Nodes) == 0 {
+		return 0
+	}
+	return 2 * float64(len(def.Links)) / float64(len(def.Nodes))
+}
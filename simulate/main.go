@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/synful/cluster-simulate/graph"
+	"github.com/synful/cluster-simulate/graph/costexpr"
 	"github.com/synful/cluster-simulate/graph/encoding"
 )
 
@@ -18,11 +21,26 @@ const (
 	ERR_USAGE = 2 + iota
 	ERR_IO
 	ERR_PARSE
+	ERR_SIMULATE
 )
 
 var (
 	graphFilename = flag.String("graph", "", "a file containing the graph to cluster")
+	graphFormat   = flag.String("format", "", "the format of the graph file: "+strings.Join(encoding.ReadableFormats(), ", ")+" (detected from its content or extension if empty)")
 	outputDir     = flag.String("output", ".", "a directory to write graph state files after each round")
+	costExpr      = flag.String("cost", "", "an expression defining the cost function (default MaxCost; see graph/costexpr)")
+	costFile      = flag.String("costFile", "", "a file containing an expression defining the cost function")
+	outputFormat  = flag.String("outputFormat", "json", "the format of graph state files: "+strings.Join(encoding.WritableFormats(), ", "))
+	compress      = flag.String("compress", "none", "the compression of graph state files and merge logs: "+strings.Join(encoding.Compressions, " or "))
+	reduce        = flag.Bool("reduce", false, "fold stub nodes into their neighbors and contract chains of degree-2 nodes before clustering; graph state files describe the original graph, with each removed node in its representative's cluster, while snapshots and the merge history describe the reduced graph (snapshots of reduced simulations can't be resumed)")
+	snapshots     = flag.Bool("snapshots", false, "write a snapshot of the full simulation state after every round (a snapshot of the final state is always written to final.snap); a snapshot given as -graph resumes the simulation it was taken from")
+
+	distributed = flag.Bool("distributed", false, "run the merge protocol as independent cluster actors exchanging messages")
+	delay       = flag.Duration("delay", 0, "the one-way delay of each message in distributed mode")
+	jitter      = flag.Duration("jitter", 0, "the maximum random delay added to each message in distributed mode")
+	loss        = flag.Float64("loss", 0, "the probability that a message is lost in distributed mode")
+	retransmit  = flag.Duration("retransmit", 0, "the retransmission timeout in distributed mode (default derived from -delay and -jitter)")
+	seed        = flag.Int64("seed", 0, "the seed used to simulate jitter and loss in distributed mode")
 )
 
 func main() {
@@ -30,49 +48,120 @@ func main() {
 	runtime.GOMAXPROCS(2)
 
 	flag.Parse()
+	encoding.Warnings = os.Stderr
 
 	if *graphFilename == "" {
 		fmt.Fprintf(os.Stderr, "No graph file specified\n")
 		os.Exit(ERR_USAGE)
 	}
+	cost, costName, err := costexpr.Load(*costExpr, *costFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading cost function: %v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+
 	f, err := os.Open(*graphFilename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening graph file: %v\n", err)
 		os.Exit(ERR_IO)
 	}
 
+	if _, err := encoding.NewFormatEncoder(*outputFormat, ioutil.Discard, encoding.Header{}); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+	if _, err := encoding.NewCompressor(ioutil.Discard, *compress); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(ERR_USAGE)
+	}
+
 	fi, err := os.Stat(*outputDir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Bad output directory: %v\n", err)
 		os.Exit(ERR_IO)
 	}
 
+	if *loss < 0 || *loss >= 1 {
+		fmt.Fprintf(os.Stderr, "Loss must be in [0, 1)\n")
+		os.Exit(ERR_USAGE)
+	}
+
 	if !fi.IsDir() {
-		fmt.Fprintf(os.Stderr, "Bad output directory: not a directory\n", err)
+		fmt.Fprintf(os.Stderr, "Bad output directory: not a directory\n")
 		os.Exit(ERR_IO)
 	}
 
-	gd, err := ioutil.ReadAll(f)
-	def, err := encoding.Unmarshal(gd)
+	format, r, err := encoding.Detect(f, *graphFilename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading graph file: %v\n", err)
+		os.Exit(ERR_IO)
+	}
+	if *graphFormat != "" {
+		format = *graphFormat
+	}
+	if *reduce && format == "snapshot" {
+		fmt.Fprintf(os.Stderr, "Cannot reduce a snapshot\n")
+		os.Exit(ERR_USAGE)
+	}
+	var g *graph.Graph
+	if format == "snapshot" {
+		var s encoding.Snapshot
+		s, err = encoding.ReadSnapshot(r)
+		// The snapshot holds only the reduced graph, not
+		// how to expand it, so later state files couldn't
+		// describe the original graph
+		if err == nil && s.Options["reduce"] == "true" {
+			fmt.Fprintf(os.Stderr, "Cannot resume a simulation run with -reduce\n")
+			os.Exit(ERR_USAGE)
+		}
+		if err == nil {
+			g, err = s.Restore(cost)
+		}
+		if err == nil && s.Cost != costName {
+			fmt.Fprintf(os.Stderr, "Warning: snapshot was taken using cost function %v\n", s.Cost)
+		}
+		provenance = s
+	} else {
+		var d encoding.Decoder
+		d, err = encoding.NewFormatDecoder(format, r, *graphFilename, nil)
+		if err == nil && *reduce {
+			g, err = readReduced(d, cost)
+		} else if err == nil {
+			g, err = encoding.ReadGraph(d, cost)
+			if err == nil {
+				provenance.SourceFingerprint = encoding.GraphFingerprint(g)
+			}
+		}
+		if err == nil {
+			provenance.SourceHeader, _ = d.Header()
+			provenance.Source = *graphFilename
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing graph file: %v\n", err)
 		os.Exit(ERR_PARSE)
 	}
+	f.Close()
+	fmt.Printf("Using cost function %v\n", costName)
 
-	g := graph.NewGraph(def, graph.MaxCost)
-
+	// Resumed simulations continue numbering
+	// rounds from where they left off
+	start := g.Rounds()
 	var t0, tprev time.Time
-	round := 0
+	round := start
 	var mergeLog *bytes.Buffer
 	roundFunc := func() {
-		if round == 0 {
+		if round == start {
 			// This is the first time we're called
 
 			t0 = time.Now()
 			tprev = t0
 
 			mergeLog = &bytes.Buffer{}
-			if err := writeLogfile(g, round); err != nil {
+			if err := writeLogfile(g, round, costName); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+			}
+			if err := writeRoundSnapshot(g, round, costName); err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 			}
 			fmt.Printf("ROUND %v...\n", round)
@@ -90,7 +179,10 @@ func main() {
 		if err := writeMergeLogfile(data, round); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 		}
-		if err := writeLogfile(g, round); err != nil {
+		if err := writeLogfile(g, round, costName); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		if err := writeRoundSnapshot(g, round, costName); err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 		}
 		fmt.Println()
@@ -102,7 +194,27 @@ func main() {
 		fmt.Fprintf(mergeLog, "%v\t%v\n", c, d)
 	}
 
-	g.MergeCallback(roundFunc, merge)
+	var total graph.DistributedStats
+	if *distributed {
+		cfg := graph.NetworkConfig{
+			Delay:             *delay,
+			Jitter:            *jitter,
+			Loss:              *loss,
+			RetransmitTimeout: *retransmit,
+			Seed:              *seed,
+		}
+		stats := func(s graph.DistributedStats) {
+			fmt.Printf("Round %v exchanged %v messages (%v bytes, %v retransmitted, %v lost); converged in %v\n",
+				round-1, s.Messages, s.Bytes, s.Retransmissions, s.Lost, s.Duration)
+		}
+		var err error
+		if _, total, err = g.DistributedMergeCallback(cfg, roundFunc, merge, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Error merging clusters: %v\n", err)
+			os.Exit(ERR_SIMULATE)
+		}
+	} else {
+		g.MergeCallback(roundFunc, merge)
+	}
 	t := time.Now()
 	diff := t.Sub(tprev)
 	fmt.Printf("Round %v took %v\n", round-1, diff)
@@ -111,40 +223,210 @@ func main() {
 	if err := writeMergeLogfile(data, round); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 	}
-	if err := writeLogfile(g, round); err != nil {
+	if err := writeLogfile(g, round, costName); err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 	}
+	if err := writeRoundSnapshot(g, round, costName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	if err := writeSnapshot(g, "final.snap", costName); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	if err := writeHistory(g); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
 
 	fmt.Println()
 	diff = t.Sub(t0)
 	fmt.Println("Graph stabilized.")
-	fmt.Printf("%v rounds completed in %v\n", round-1, diff)
-	if round != 1 {
-		fmt.Printf("Average time per round: %v\n", diff/time.Duration(round-1))
+	completed := round - 1 - start
+	fmt.Printf("%v rounds completed in %v\n", completed, diff)
+	if completed != 0 {
+		fmt.Printf("Average time per round: %v\n", diff/time.Duration(completed))
+	}
+	if *distributed {
+		fmt.Printf("%v messages (%v bytes, %v retransmitted, %v lost) exchanged in total\n",
+			total.Messages, total.Bytes, total.Retransmissions, total.Lost)
+	}
+}
+
+// A logfile which closes both the
+// compressor and the underlying file
+type logfile struct {
+	io.WriteCloser
+	f *os.File
+}
+
+func (l logfile) Close() error {
+	err := l.WriteCloser.Close()
+	if ferr := l.f.Close(); err == nil {
+		err = ferr
 	}
+	return err
 }
 
-func writeLogfile(g *graph.Graph, round int) error {
-	logfile := filepath.Join(*outputDir, fmt.Sprintf("%04d.def", round))
-	f, err := os.Create(logfile)
+// Create a logfile in the output directory
+// (compressing it if requested)
+func createLogfile(name string) (io.WriteCloser, error) {
+	f, err := os.Create(filepath.Join(*outputDir, name+encoding.CompressionExt(*compress)))
 	if err != nil {
-		return fmt.Errorf("Error creating logfile: %v", err)
+		return nil, fmt.Errorf("Error creating logfile: %v", err)
 	}
-	def := g.GraphDef()
-	data, err := encoding.Marshal(def)
+	w, err := encoding.NewCompressor(f, *compress)
 	if err != nil {
-		return fmt.Errorf("Error marshalling graph def: %v", err)
+		f.Close()
+		return nil, err
 	}
-	_, err = f.Write(data)
-	return err
+	return logfile{w, f}, nil
+}
+
+func writeLogfile(g *graph.Graph, round int, costName string) error {
+	f, err := createLogfile(fmt.Sprintf("%04d.def", round))
+	if err != nil {
+		return err
+	}
+	h := encoding.Header{
+		Generator: "simulate",
+		Meta: map[string]string{
+			"cost":  costName,
+			"round": fmt.Sprint(round),
+		},
+	}
+	var enc encoding.Encoder
+	if reduction != nil {
+		h.Meta["reduced"] = "true"
+		var def graph.GraphDef
+		def, err = reduction.Expand(g.GraphDef())
+		if err == nil {
+			enc, err = encoding.NewFormatEncoder(*outputFormat, f, h)
+		}
+		if err == nil {
+			err = encoding.Encode(enc, def)
+		}
+	} else {
+		enc, err = encoding.NewFormatEncoder(*outputFormat, f, h)
+		if err == nil {
+			err = encoding.WriteGraph(enc, g)
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing graph def: %v", err)
+	}
+	return nil
+}
+
+// How the graph being simulated was reduced,
+// or nil if it wasn't
+var reduction *graph.Reduction
+
+// Read and reduce a graph, recording the
+// fingerprint of the original in provenance
+func readReduced(d encoding.Decoder, cost graph.Cost) (*graph.Graph, error) {
+	def, err := encoding.Decode(d)
+	if err != nil {
+		return nil, err
+	}
+	reduced, r, err := graph.Reduce(def)
+	if err != nil {
+		return nil, err
+	}
+	reduction = r
+	// Fingerprint the graph as it would have been read
+	// without -reduce, so that the same graph file has
+	// the same source fingerprint either way
+	provenance.SourceFingerprint = encoding.GraphFingerprint(graph.NewGraph(def, cost))
+	fmt.Printf("Reduced graph from %v nodes and %v links to %v nodes and %v links (folded %v stubs and contracted %v chain nodes)\n",
+		len(def.Nodes), len(def.Links), len(reduced.Nodes), len(reduced.Links), r.Stubs, r.ChainNodes)
+	return graph.NewGraph(reduced, cost), nil
+}
+
+// The provenance of the graph being simulated,
+// recorded in every snapshot: either the source
+// fields describing the graph file, or, if the
+// simulation was resumed, the snapshot it was
+// resumed from
+var provenance encoding.Snapshot
+
+func writeRoundSnapshot(g *graph.Graph, round int, costName string) error {
+	if !*snapshots {
+		return nil
+	}
+	return writeSnapshot(g, fmt.Sprintf("%04d.snap", round), costName)
+}
+
+func writeSnapshot(g *graph.Graph, name, costName string) error {
+	s := encoding.NewSnapshot(g)
+	s.Generator = "simulate"
+	s.Cost = costName
+	s.Seed = *seed
+	s.Options = map[string]string{
+		"distributed": fmt.Sprint(*distributed),
+		"reduce":      fmt.Sprint(reduction != nil),
+	}
+	if *distributed {
+		s.Options["delay"] = delay.String()
+		s.Options["jitter"] = jitter.String()
+		s.Options["loss"] = fmt.Sprint(*loss)
+		s.Options["retransmit"] = retransmit.String()
+	}
+	s.Source = provenance.Source
+	s.SourceFingerprint = provenance.SourceFingerprint
+	s.SourceHeader = provenance.SourceHeader
+
+	f, err := createLogfile(name)
+	if err != nil {
+		return err
+	}
+	err = encoding.WriteSnapshot(f, s)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing snapshot: %v", err)
+	}
+	return nil
 }
 
 func writeMergeLogfile(data []byte, round int) error {
-	logfile := filepath.Join(*outputDir, fmt.Sprintf("%04d-merge.log", round-1))
-	f, err := os.Create(logfile)
+	f, err := createLogfile(fmt.Sprintf("%04d-merge.log", round-1))
 	if err != nil {
-		return fmt.Errorf("Error creating logfile: %v", err)
+		return err
 	}
 	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	return err
 }
+
+// Write the merge history as a dendrogram in both
+// JSON and Newick formats. The clustering after
+// round N (as in NNNN.def) is the history cut at
+// round N.
+func writeHistory(g *graph.Graph) error {
+	roots := g.MergeHistory()
+	data, err := graph.HistoryJSON(roots)
+	if err != nil {
+		return err
+	}
+	for name, data := range map[string][]byte{
+		"history.json": data,
+		"history.nwk":  []byte(graph.Newick(roots)),
+	} {
+		f, err := createLogfile(name)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("Error writing merge history: %v", err)
+		}
+	}
+	return nil
+}